@@ -1,7 +1,8 @@
 // Package autocomplete provides a library for building auto complete services
 // with a Redis backend.
 //
-// it uses http://github.com/garyburd/redigo/redis as it's Redis driver.
+// it executes Redis commands on the connections of a ConnSource, the garyburd,
+// gomodule and goredis packages adapt redigo pools and go-redis clients to it.
 //
 // it borrows ideas from:
 //
@@ -18,8 +19,9 @@ package autocomplete
 
 import (
 	"errors"
-
-	"github.com/garyburd/redigo/redis"
+	"fmt"
+	"sync"
+	"time"
 )
 
 const (
@@ -46,30 +48,121 @@ const (
 	TermsIndexing = 1
 )
 
-// Error objects
+// Error objects, errors of common failures match them with errors.Is and
+// are an *Error that wraps the cause
 var (
 	ErrInvalidIndexType = errors.New("invalid index type")
+
+	// ErrAlreadyIndexed is returned when a document is indexed in a terms
+	// indexing index that already contains it
+	ErrAlreadyIndexed = errors.New("document is already indexed")
+
+	// ErrDocumentNotFound is returned when a document that is updated or
+	// removed is not in the index
+	ErrDocumentNotFound = errors.New("document not found")
+
+	// ErrIndexNotFound is returned instead of ErrDocumentNotFound when the
+	// index has no documents at all
+	ErrIndexNotFound = errors.New("index not found")
+
+	// ErrBackendUnavailable is returned when Redis can not be reached or a
+	// connection to it failed
+	ErrBackendUnavailable = errors.New("backend unavailable")
+
+	// ErrPubSubUnsupported is returned when invalidations are subscribed to
+	// with a connection source that is not a Subscriber
+	ErrPubSubUnsupported = errors.New("connection source does not support Pub/Sub")
 )
 
-// Autocomplete service
+// Autocomplete service, it is safe for concurrent use once it is configured.
+//
+// every setting has an Option of New, the Set methods are not synchronized
+// with searches and writes and must only be called before the service is
+// first used.
 type Autocomplete struct {
-	pool      *redis.Pool
+	pool      ConnSource
+	reads     ConnSource
 	prefix    string
 	indexType int
+	keySchema int
+	hashTags  bool
+	phonetic  bool
+	weights   RelevanceWeights
+	halfLives map[string]time.Duration
+	clicks    bool
+
+	personalization Personalization
+	queryRetention  time.Duration
+	queryLogSize    int
+
+	cache                *resultCache
+	publishInvalidations bool
+	intersectionTTL      time.Duration
+	serverSide           bool
+
+	analyzer         Analyzer
+	batchSize        int
+	fetchConcurrency int
+	logger           Logger
+	metrics          Metrics
+
+	layouts      map[string]layout
+	layoutsMutex *sync.Mutex
+	layoutTTL    time.Duration
 
-	scripts map[string]*redis.Script
+	scripts map[string]*script
 }
 
-// New returns a pointer to a new Autocomplete service
-func New(pool *redis.Pool, prefix string, indexType int) *Autocomplete {
+// New returns a pointer to a new Autocomplete service of an index type,
+// configured by the given options which are applied in order. an unknown
+// index type fails with ErrInvalidIndexType.
+func New(pool ConnSource, prefix string, indexType int,
+	opts ...Option) (*Autocomplete, error) {
+
+	if indexType != PrefixesIndexing && indexType != TermsIndexing {
+		return nil, fmt.Errorf("%w %d", ErrInvalidIndexType, indexType)
+	}
+
+	if pool == nil {
+		return nil, fmt.Errorf("nil connection source")
+	}
+
 	a := &Autocomplete{
 		pool:      pool,
+		reads:     pool,
 		prefix:    prefix,
 		indexType: indexType,
-		scripts:   make(map[string]*redis.Script),
+		keySchema: KeySchemaLegacy,
+		weights:   DefaultRelevanceWeights,
+		halfLives: make(map[string]time.Duration),
+		scripts:   make(map[string]*script),
+
+		personalization: DefaultPersonalization,
+		intersectionTTL: DefaultIntersectionTTL,
+		layoutTTL:       DefaultLayoutTTL,
+		queryLogSize:    DefaultQueryLogSize,
+		analyzer:        DefaultAnalyzer,
+		batchSize:       defaultBatchSize,
+		layouts:         make(map[string]layout),
+		layoutsMutex:    &sync.Mutex{},
 	}
 
 	a.initScripts()
 
-	return a
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// SetPhonetic enables or disables maintaining a phonetic index alongside the
+// autocomplete index, which is required for phonetic matching in searches.
+//
+// documents indexed while the phonetic index was disabled are not matched
+// phonetically until they are re-indexed.
+func (a *Autocomplete) SetPhonetic(enabled bool) {
+	a.phonetic = enabled
 }
@@ -1,44 +1,33 @@
 package autocomplete
 
 import (
+	"errors"
 	"testing"
-	"time"
-
-	"github.com/garyburd/redigo/redis"
 )
 
-func ExampleNew() {
-	pool := &redis.Pool{
-		MaxIdle:     3,
-		MaxActive:   20,
-		IdleTimeout: 240 * time.Second,
-		Wait:        true,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", "localhost:6379")
-			if err != nil {
-				return nil, err
-			}
-
-			return c, err
-		},
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("PING")
-			return err
-		},
-	}
-
-	defer pool.Close()
-
-	New(pool, "ac", PrefixesIndexing)
-}
-
 func TestNew(t *testing.T) {
-	p := &redis.Pool{}
+	p := &fakeSource{}
 	prefix := "test_prefix"
 
-	s := New(p, prefix, PrefixesIndexing)
+	s, err := New(p, prefix, PrefixesIndexing)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	if s.pool != p || s.prefix != prefix || s.indexType != PrefixesIndexing {
 		t.Fail()
 	}
+
+	if s.batchSize != defaultBatchSize {
+		t.Fatalf("expected batch size %d, got %d", defaultBatchSize,
+			s.batchSize)
+	}
+
+	if _, err := New(p, prefix, 3); !errors.Is(err, ErrInvalidIndexType) {
+		t.Fatalf("expected ErrInvalidIndexType, got %v", err)
+	}
+
+	if _, err := New(nil, prefix, PrefixesIndexing); err == nil {
+		t.Fatal("expected an error for a nil pool")
+	}
 }
@@ -0,0 +1,322 @@
+package autocomplete
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// resubscribeDelay is the time between attempts to subscribe to cache
+// invalidations after the subscription failed
+const resubscribeDelay = time.Second
+
+// SetCache enables caching the results of searches in process, up to size
+// results are cached for ttl and the least recently used results are evicted
+// first. a size of 0 disables the cache.
+//
+// the cached results of an index are invalidated when the service writes to
+// the index. writes of other processes invalidate them when those processes
+// publish invalidations, see SetCacheInvalidation, otherwise results may be
+// stale for up to ttl. results of documents that expire are cached up to ttl
+// after their expiry.
+//
+// searches with ReadYourWrites bypass the cache, SetCache should be called
+// before the service is used.
+func (a *Autocomplete) SetCache(size int, ttl time.Duration) {
+	if size <= 0 {
+		a.cache = nil
+		return
+	}
+
+	a.cache = newResultCache(size, ttl)
+}
+
+// SetCacheInvalidation enables or disables publishing the indexes the service
+// writes to, so other processes drop their cached results of these indexes.
+// it should be enabled in every process that writes to indexes that are
+// cached by any process.
+func (a *Autocomplete) SetCacheInvalidation(enabled bool) {
+	a.publishInvalidations = enabled
+}
+
+// invalidationsChannel is the Pub/Sub channel of the cache invalidations of
+// every index
+func (a *Autocomplete) invalidationsChannel() string {
+	if a.keySchema == KeySchemaLegacy {
+		return a.prefix + ":$inv"
+	}
+
+	return a.prefix + ":" + strconv.Itoa(a.keySchema) + ":$inv"
+}
+
+// invalidate drops the cached results and the stored intersections of an
+// index after a write to it and publishes the invalidation when enabled
+func (a *Autocomplete) invalidate(conn *redisConn, index string) error {
+	if err := a.dropIntersections(conn, index); err != nil {
+		return err
+	}
+
+	return a.invalidateResults(conn, index)
+}
+
+// invalidateResults drops the cached results of an index after a write that
+// does not change its ZSETs, such as activity and per user selections, and
+// publishes the invalidation when enabled
+func (a *Autocomplete) invalidateResults(conn *redisConn, index string) error {
+	if a.cache != nil {
+		a.cache.invalidate(index)
+	}
+
+	if !a.publishInvalidations {
+		return nil
+	}
+
+	if _, err := conn.Do("PUBLISH", a.invalidationsChannel(), index); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Invalidator drops the cached results of indexes written to by other
+// processes
+type Invalidator struct {
+	stop chan struct{}
+	sub  Subscription
+	wg   sync.WaitGroup
+
+	mutex sync.Mutex
+}
+
+// StartInvalidator subscribes to the invalidations published by other
+// processes and drops the cached results of their indexes, all of the cached
+// results are dropped when the subscription is interrupted. subscription
+// errors are passed to onError if it is not nil, otherwise they are logged.
+//
+// it fails with ErrPubSubUnsupported when the service's connection source is
+// not a Subscriber, like *Cluster and the source of the goredis package.
+func (a *Autocomplete) StartInvalidator(onError func(error)) (*Invalidator,
+	error) {
+
+	s, ok := a.pool.(Subscriber)
+	if !ok {
+		return nil, ErrPubSubUnsupported
+	}
+
+	inv := &Invalidator{stop: make(chan struct{})}
+
+	inv.wg.Add(1)
+	go func() {
+		defer inv.wg.Done()
+
+		for {
+			err := inv.subscribe(a, s)
+			if inv.stopped() {
+				return
+			}
+
+			if onError != nil {
+				onError(err)
+			} else {
+				a.logf("autocomplete: invalidations subscription failed: %v",
+					err)
+			}
+
+			select {
+			case <-inv.stop:
+				return
+
+			case <-time.After(resubscribeDelay):
+			}
+		}
+	}()
+
+	return inv, nil
+}
+
+func (inv *Invalidator) stopped() bool {
+	select {
+	case <-inv.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// subscribe receives the invalidations until the subscription fails
+func (inv *Invalidator) subscribe(a *Autocomplete, s Subscriber) error {
+	sub, err := s.Subscribe(a.invalidationsChannel())
+	if err != nil {
+		return unavailable(err)
+	}
+
+	inv.mutex.Lock()
+	if inv.stopped() {
+		inv.mutex.Unlock()
+		sub.Close()
+		return nil
+	}
+
+	inv.sub = sub
+	inv.mutex.Unlock()
+
+	defer func() {
+		inv.mutex.Lock()
+		inv.sub = nil
+		inv.mutex.Unlock()
+
+		sub.Close()
+	}()
+
+	// invalidations may have been missed before the subscription
+	if a.cache != nil {
+		a.cache.invalidateAll()
+	}
+
+	for {
+		index, err := sub.Receive()
+		if err != nil {
+			if a.cache != nil {
+				a.cache.invalidateAll()
+			}
+
+			return unavailable(err)
+		}
+
+		if a.cache != nil {
+			a.cache.invalidate(string(index))
+		}
+	}
+}
+
+// Stop ends the subscription to the invalidations and waits for it to end
+func (inv *Invalidator) Stop() {
+	inv.mutex.Lock()
+	close(inv.stop)
+	if inv.sub != nil {
+		inv.sub.Close()
+	}
+	inv.mutex.Unlock()
+
+	inv.wg.Wait()
+}
+
+// resultCache is a bounded LRU cache of search results, results are versioned
+// so results of searches that started before an invalidation are not cached
+type resultCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+
+	// version is incremented by every invalidation, invalidated holds the
+	// version of the last invalidation of every index and all the version
+	// of the last invalidation of all indexes
+	version     uint64
+	invalidated map[string]uint64
+	all         uint64
+}
+
+type cacheKey struct {
+	index string
+	query string
+	opts  SearchOptions
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	query   string
+	results [][]byte
+	version uint64
+	expires time.Time
+}
+
+func newResultCache(size int, ttl time.Duration) *resultCache {
+	return &resultCache{
+		size:        size,
+		ttl:         ttl,
+		entries:     make(map[cacheKey]*list.Element),
+		lru:         list.New(),
+		invalidated: make(map[string]uint64),
+	}
+}
+
+// get returns the cached results and the executed query of a search, or the
+// current version when they are not cached
+func (c *resultCache) get(key cacheKey) ([][]byte, string, uint64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if c.valid(entry) {
+			c.lru.MoveToFront(e)
+			return append([][]byte{}, entry.results...), entry.query,
+				c.version, true
+		}
+
+		c.lru.Remove(e)
+		delete(c.entries, key)
+	}
+
+	return nil, "", c.version, false
+}
+
+// add caches the results of a search that started at the given version,
+// unless the index was invalidated since
+func (c *resultCache) add(key cacheKey, query string, results [][]byte,
+	version uint64) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := &cacheEntry{
+		key:     key,
+		query:   query,
+		results: append([][]byte{}, results...),
+		version: version,
+		expires: time.Now().Add(c.ttl),
+	}
+
+	if !c.valid(entry) {
+		return
+	}
+
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *resultCache) valid(entry *cacheEntry) bool {
+	return time.Now().Before(entry.expires) &&
+		entry.version >= c.invalidated[entry.key.index] &&
+		entry.version >= c.all
+}
+
+func (c *resultCache) invalidate(index string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.version++
+	c.invalidated[index] = c.version
+}
+
+func (c *resultCache) invalidateAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.version++
+	c.all = c.version
+}
@@ -0,0 +1,146 @@
+package autocomplete
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResultCache(t *testing.T) {
+	c := newResultCache(2, time.Minute)
+
+	k1 := cacheKey{index: "i1", query: "a"}
+	k2 := cacheKey{index: "i1", query: "b"}
+	k3 := cacheKey{index: "i2", query: "a"}
+	results := [][]byte{[]byte("1")}
+
+	_, _, v, hit := c.get(k1)
+	if hit {
+		t.Fatal("expected a miss")
+	}
+
+	c.add(k1, "a", results, v)
+	c.add(k2, "b", results, v)
+
+	cached, query, _, hit := c.get(k1)
+	if !hit || query != "a" || !reflect.DeepEqual(cached, results) {
+		t.Fatalf("expected a hit for %+v", k1)
+	}
+
+	// the least recently used results are evicted
+	c.add(k3, "a", results, v)
+	if _, _, _, hit := c.get(k2); hit {
+		t.Fatalf("expected %+v to be evicted", k2)
+	}
+
+	// results of searches that started before an invalidation are not cached
+	_, _, v, _ = c.get(k2)
+	c.invalidate("i1")
+	c.add(k2, "b", results, v)
+
+	if _, _, _, hit := c.get(k2); hit {
+		t.Fatalf("expected a miss for %+v", k2)
+	}
+
+	if _, _, _, hit := c.get(k1); hit {
+		t.Fatalf("expected %+v to be invalidated", k1)
+	}
+
+	if _, _, _, hit := c.get(k3); !hit {
+		t.Fatalf("expected a hit for %+v", k3)
+	}
+
+	c.invalidateAll()
+	if _, _, _, hit := c.get(k3); hit {
+		t.Fatalf("expected %+v to be invalidated", k3)
+	}
+
+	c = newResultCache(2, 0)
+	_, _, v, _ = c.get(k1)
+	c.add(k1, "a", results, v)
+	if _, _, _, hit := c.get(k1); hit {
+		t.Fatalf("expected %+v to expire", k1)
+	}
+}
+
+// fakeSubscriber is a connection source whose subscriptions receive the
+// messages sent on its channel
+type fakeSubscriber struct {
+	*fakeSource
+	messages chan []byte
+}
+
+func (s fakeSubscriber) Subscribe(channel string) (Subscription, error) {
+	return &fakeSubscription{messages: s.messages,
+		closed: make(chan struct{})}, nil
+}
+
+type fakeSubscription struct {
+	messages chan []byte
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func (s *fakeSubscription) Receive() ([]byte, error) {
+	select {
+	case m := <-s.messages:
+		return m, nil
+	case <-s.closed:
+		return nil, errors.New("subscription closed")
+	}
+}
+
+func (s *fakeSubscription) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+
+	return nil
+}
+
+func TestStartInvalidator(t *testing.T) {
+	a, err := New(&fakeSource{}, "ac", PrefixesIndexing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.StartInvalidator(nil); err != ErrPubSubUnsupported {
+		t.Fatalf("expected ErrPubSubUnsupported, got %v", err)
+	}
+
+	source := fakeSubscriber{&fakeSource{}, make(chan []byte)}
+	a, err = New(source, "ac", PrefixesIndexing, WithCache(10, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := []error{}
+	inv, err := a.StartInvalidator(func(err error) {
+		errs = append(errs, err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// messages are received in order once the subscription is started
+	source.messages <- []byte("other")
+
+	k := cacheKey{index: "i", query: "q"}
+	_, _, v, _ := a.cache.get(k)
+	a.cache.add(k, "q", [][]byte{[]byte("r")}, v)
+
+	source.messages <- []byte("i")
+	source.messages <- []byte("other")
+
+	if _, _, _, hit := a.cache.get(k); hit {
+		t.Fatal("expected the results of i to be invalidated")
+	}
+
+	inv.Stop()
+
+	if len(errs) != 0 {
+		t.Fatalf("unexpected subscription errors %v", errs)
+	}
+}
@@ -0,0 +1,611 @@
+package autocomplete
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// clusterSlots is the number of hash slots of a Redis Cluster
+const clusterSlots = 16384
+
+// SetHashTags enables or disables hash tagging the keys of every index with
+// the index name, so all of the keys of an index are in the same Redis Cluster
+// slot. it is required with Redis Cluster, which rejects the multi-key
+// commands, scripts and transactions of an index otherwise.
+//
+// hash tags change every key of an index, indexes stored without them should
+// be re-indexed.
+func (a *Autocomplete) SetHashTags(enabled bool) {
+	a.hashTags = enabled
+}
+
+// Cluster is a connection source for Redis Cluster, its connections route
+// every command to the node that serves the slot of the command's first key.
+//
+// a transaction is sent to the node of its first key, so the keys of a
+// transaction and of a SCAN pattern must be in a single slot, which is the
+// case for the keys of an index when hash tags are enabled.
+type Cluster struct {
+	newSource func(addr string) ConnSource
+
+	mutex   sync.RWMutex
+	seeds   []string
+	sources map[string]ConnSource
+	slots   [clusterSlots]string
+}
+
+// NewCluster returns a connection source for the Redis Cluster with the given
+// seed node addresses, connections to every node are taken from a source
+// created with newSource, which is closed with the cluster if it is an
+// io.Closer
+func NewCluster(addrs []string,
+	newSource func(addr string) ConnSource) (*Cluster, error) {
+
+	c := &Cluster{
+		newSource: newSource,
+		seeds:     addrs,
+		sources:   make(map[string]ConnSource),
+	}
+
+	if err := c.Refresh(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get returns a connection that routes commands to the cluster's nodes, the
+// connection must be closed
+func (c *Cluster) Get() Conn {
+	return &clusterConn{c: c, conns: make(map[string]*redisConn)}
+}
+
+// Refresh reloads the slots served by every node from the first node that
+// replies to CLUSTER SLOTS
+func (c *Cluster) Refresh() error {
+	c.mutex.RLock()
+	addrs := append([]string{}, c.seeds...)
+	for addr := range c.sources {
+		addrs = appendUnique(addrs, addr)
+	}
+	c.mutex.RUnlock()
+
+	var err error
+	for _, addr := range addrs {
+		var slots []interface{}
+		slots, err = c.clusterSlots(addr)
+		if err != nil {
+			continue
+		}
+
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		for _, s := range slots {
+			start, end, addr, err := slotRange(s)
+			if err != nil {
+				return err
+			}
+
+			for i := start; i <= end && i < clusterSlots; i++ {
+				c.slots[i] = addr
+			}
+		}
+
+		return nil
+	}
+
+	if err == nil {
+		err = errors.New("no cluster nodes")
+	}
+
+	return err
+}
+
+func (c *Cluster) clusterSlots(addr string) ([]interface{}, error) {
+	conn := c.source(addr).Get()
+	defer conn.Close()
+
+	return replyValues(conn.Do("CLUSTER", "SLOTS"))
+}
+
+// slotRange returns the first and the last slot of a CLUSTER SLOTS entry and
+// the address of the master that serves them
+func slotRange(entry interface{}) (int, int, string, error) {
+	values, err := replyValues(entry, nil)
+	if err != nil || len(values) < 3 {
+		return 0, 0, "", fmt.Errorf("invalid slot range %v", entry)
+	}
+
+	master, err := replyValues(values[2], nil)
+	if err != nil || len(master) < 2 {
+		return 0, 0, "", fmt.Errorf("invalid slot range %v", entry)
+	}
+
+	start, err := replyInt(values[0], nil)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	end, err := replyInt(values[1], nil)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	host, err := replyString(master[0], nil)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	port, err := replyInt(master[1], nil)
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	return start, end, host + ":" + strconv.Itoa(port), nil
+}
+
+// Close closes the connection sources of all nodes that are io.Closers
+func (c *Cluster) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var err error
+	for addr, s := range c.sources {
+		if e := closeSource(s); e != nil && err == nil {
+			err = e
+		}
+
+		delete(c.sources, addr)
+	}
+
+	return err
+}
+
+func (c *Cluster) source(addr string) ConnSource {
+	c.mutex.RLock()
+	s, ok := c.sources[addr]
+	c.mutex.RUnlock()
+
+	if ok {
+		return s
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if s, ok := c.sources[addr]; ok {
+		return s
+	}
+
+	s = c.newSource(addr)
+	c.sources[addr] = s
+
+	return s
+}
+
+// closeSource closes a connection source if it is an io.Closer
+func closeSource(s ConnSource) error {
+	if c, ok := s.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// node returns the address of the node that serves a slot, or of any node
+// for a negative slot
+func (c *Cluster) node(slot int) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if slot >= 0 && c.slots[slot] != "" {
+		return c.slots[slot]
+	}
+
+	for _, addr := range c.slots {
+		if addr != "" {
+			return addr
+		}
+	}
+
+	return c.seeds[0]
+}
+
+func (c *Cluster) setNode(slot int, addr string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.slots[slot] = addr
+}
+
+// clusterConn routes commands to the connections of the cluster's nodes,
+// replies are received in the order the commands were sent
+type clusterConn struct {
+	c     *Cluster
+	conns map[string]*redisConn
+
+	// pending are the nodes of the sent commands whose replies were not
+	// received
+	pending []string
+
+	// node is the node of the current transaction or watch, the transaction
+	// of a deferred MULTI is sent to the node of its first command
+	node     string
+	multi    bool
+	watching bool
+	deferred bool
+
+	err error
+}
+
+func (cc *clusterConn) conn(addr string) *redisConn {
+	conn, ok := cc.conns[addr]
+	if !ok {
+		conn = newRedisConn(cc.c.source(addr).Get())
+		cc.conns[addr] = conn
+	}
+
+	return conn
+}
+
+func (cc *clusterConn) send(addr, cmd string, args ...interface{}) error {
+	if err := cc.conn(addr).Send(cmd, args...); err != nil {
+		return err
+	}
+
+	cc.pending = append(cc.pending, addr)
+	return nil
+}
+
+// sendDeferred sends a deferred MULTI to the given node
+func (cc *clusterConn) sendDeferred(addr string) error {
+	if !cc.deferred {
+		return nil
+	}
+
+	cc.deferred = false
+	cc.node = addr
+
+	return cc.send(addr, "MULTI")
+}
+
+func (cc *clusterConn) Send(cmd string, args ...interface{}) error {
+	if cc.err != nil {
+		return cc.err
+	}
+
+	cmd = strings.ToUpper(cmd)
+
+	switch cmd {
+	case "MULTI":
+		cc.multi = true
+		if cc.node == "" {
+			cc.deferred = true
+			return nil
+		}
+
+		return cc.send(cc.node, cmd)
+
+	case "EXEC", "DISCARD":
+		addr := cc.node
+		if addr == "" {
+			addr = cc.c.node(-1)
+		}
+
+		if err := cc.sendDeferred(addr); err != nil {
+			return err
+		}
+
+		cc.node = ""
+		cc.multi = false
+		cc.watching = false
+
+		return cc.send(addr, cmd, args...)
+
+	case "UNWATCH":
+		addr := cc.node
+		if addr == "" {
+			addr = cc.c.node(-1)
+		}
+
+		cc.watching = false
+		if !cc.multi {
+			cc.node = ""
+		}
+
+		return cc.send(addr, cmd, args...)
+	}
+
+	addr := cc.node
+	if addr == "" {
+		addr = cc.c.node(commandSlot(cmd, args))
+	}
+
+	if err := cc.sendDeferred(addr); err != nil {
+		return err
+	}
+
+	if cmd == "WATCH" {
+		cc.node = addr
+		cc.watching = true
+	}
+
+	return cc.send(addr, cmd, args...)
+}
+
+func (cc *clusterConn) Flush() error {
+	if cc.err != nil {
+		return cc.err
+	}
+
+	if cc.deferred {
+		if err := cc.sendDeferred(cc.c.node(-1)); err != nil {
+			return err
+		}
+	}
+
+	for _, conn := range cc.conns {
+		if err := conn.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cc *clusterConn) Receive() (interface{}, error) {
+	if cc.err != nil {
+		return nil, cc.err
+	}
+
+	if len(cc.pending) == 0 {
+		return nil, errors.New("no pending replies")
+	}
+
+	addr := cc.pending[0]
+	cc.pending = cc.pending[1:]
+
+	return cc.conn(addr).Receive()
+}
+
+func (cc *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cc.err != nil {
+		return nil, cc.err
+	}
+
+	// a single command outside of a transaction follows redirections
+	retry := cmd != "" && len(cc.pending) == 0 && cc.node == "" &&
+		!cc.deferred && !cc.multi && !cc.watching
+
+	if cmd != "" {
+		if err := cc.Send(cmd, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := cc.receiveAll()
+	if !retry || err == nil {
+		return reply, err
+	}
+
+	e, ok := err.(ReplyError)
+	if !ok {
+		return reply, err
+	}
+
+	fields := strings.Fields(string(e))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return reply, err
+	}
+
+	slot, _ := strconv.Atoi(fields[1])
+	addr := fields[2]
+
+	if fields[0] == "MOVED" {
+		if err := cc.c.Refresh(); err != nil && slot >= 0 &&
+			slot < clusterSlots {
+
+			cc.c.setNode(slot, addr)
+		}
+	} else {
+		if err := cc.send(addr, "ASKING"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cc.send(addr, cmd, args...); err != nil {
+		return nil, err
+	}
+
+	return cc.receiveAll()
+}
+
+// Pipeline routes the commands like Send and returns their replies, error
+// replies included
+func (cc *clusterConn) Pipeline(cmds []Command) ([]interface{}, error) {
+	if cc.err != nil {
+		return nil, cc.err
+	}
+
+	for _, cmd := range cmds {
+		if err := cc.Send(cmd.Name, cmd.Args...); err != nil {
+			return nil, err
+		}
+	}
+
+	return cc.receiveReplies()
+}
+
+// receiveReplies flushes and receives all pending replies, error replies
+// included
+func (cc *clusterConn) receiveReplies() ([]interface{}, error) {
+	if err := cc.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := []interface{}{}
+	for len(cc.pending) > 0 {
+		r, err := cc.Receive()
+		if e, ok := err.(ReplyError); ok {
+			r = e
+		} else if err != nil {
+			cc.err = err
+			return nil, err
+		}
+
+		replies = append(replies, r)
+	}
+
+	return replies, nil
+}
+
+// receiveAll flushes and receives all pending replies, it returns the last
+// reply and the first error reply
+func (cc *clusterConn) receiveAll() (interface{}, error) {
+	replies, err := cc.receiveReplies()
+	if err != nil {
+		return nil, err
+	}
+
+	var reply interface{}
+	for _, r := range replies {
+		if e, ok := r.(ReplyError); ok && err == nil {
+			err = e
+		}
+
+		reply = r
+	}
+
+	return reply, err
+}
+
+func (cc *clusterConn) Close() error {
+	var err error
+	for _, conn := range cc.conns {
+		if e := conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	cc.conns = nil
+	cc.err = errors.New("connection closed")
+
+	return err
+}
+
+// commandSlot returns the slot of the first key of a command, or -1 if it has
+// no keys. the slot of SCAN is the slot of its pattern's hash tag.
+func commandSlot(cmd string, args []interface{}) int {
+	switch cmd {
+	case "EVAL", "EVALSHA":
+		if len(args) < 3 || argString(args[1]) == "0" {
+			return -1
+		}
+
+		return keySlot(argString(args[2]))
+
+	case "SCAN":
+		for i := 1; i+1 < len(args); i++ {
+			if strings.ToUpper(argString(args[i])) == "MATCH" {
+				if tag, ok := patternTag(argString(args[i+1])); ok {
+					return crc16(tag) % clusterSlots
+				}
+			}
+		}
+
+		return -1
+
+	case "PING", "ECHO", "AUTH", "SELECT", "INFO", "SCRIPT", "KEYS",
+		"DBSIZE", "FLUSHALL", "FLUSHDB", "CLUSTER", "ASKING", "PUBLISH",
+		"SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "TIME":
+
+		return -1
+	}
+
+	if len(args) == 0 {
+		return -1
+	}
+
+	return keySlot(argString(args[0]))
+}
+
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// keySlot returns the Redis Cluster slot of a key, only the hash tag of the
+// key is hashed if it has one
+func keySlot(key string) int {
+	if i := strings.Index(key, "{"); i >= 0 {
+		if j := strings.Index(key[i+1:], "}"); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+
+	return crc16(key) % clusterSlots
+}
+
+// patternTag returns the hash tag of the keys that match a glob style
+// pattern, if the pattern determines it
+func patternTag(pattern string) (string, bool) {
+	tag := []byte{}
+	open := false
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+
+		if c == '\\' && i+1 < len(pattern) {
+			i++
+			c = pattern[i]
+		} else if c == '*' || c == '?' || c == '[' {
+			return "", false
+		}
+
+		switch {
+		case c == '{' && !open:
+			open = true
+
+		case c == '}' && open:
+			if len(tag) == 0 {
+				return "", false
+			}
+
+			return string(tag), true
+
+		case open:
+			tag = append(tag, c)
+		}
+	}
+
+	return "", false
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum used for Redis Cluster slots
+func crc16(s string) int {
+	crc := uint16(0)
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return int(crc)
+}
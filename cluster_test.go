@@ -0,0 +1,239 @@
+package autocomplete
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	if keySlot("123456789") != 0x31C3 {
+		t.Errorf("unexpected slot %d", keySlot("123456789"))
+	}
+
+	if keySlot("foo") != 12182 {
+		t.Errorf("unexpected slot %d", keySlot("foo"))
+	}
+
+	if keySlot("{user1000}.following") != keySlot("{user1000}.followers") ||
+		keySlot("{user1000}.following") != keySlot("user1000") {
+
+		t.Error("expected keys with the same hash tag in the same slot")
+	}
+
+	if keySlot("foo{}{bar}") != crc16("foo{}{bar}")%clusterSlots {
+		t.Error("expected an empty hash tag to be ignored")
+	}
+}
+
+func TestCommandSlot(t *testing.T) {
+	tagged := keySlot("{index}")
+
+	if s := commandSlot("ZADD", []interface{}{"ac:2:p:{index}:te", 1,
+		"m"}); s != tagged {
+
+		t.Errorf("unexpected slot %d", s)
+	}
+
+	if s := commandSlot("EVALSHA", []interface{}{"sha", 2,
+		[]byte("ac:2:$:{index}"), "ac:2:$$:{index}", "arg"}); s != tagged {
+
+		t.Errorf("unexpected slot %d", s)
+	}
+
+	if s := commandSlot("EVAL", []interface{}{"src", 0}); s != -1 {
+		t.Errorf("unexpected slot %d", s)
+	}
+
+	if s := commandSlot("SCAN", []interface{}{"0", "MATCH",
+		globEscape("ac:2:p:{index}:") + "*", "COUNT", 1000}); s != tagged {
+
+		t.Errorf("unexpected slot %d", s)
+	}
+
+	if s := commandSlot("SCAN", []interface{}{"0", "MATCH",
+		"ac:index:*"}); s != -1 {
+
+		t.Errorf("unexpected slot %d", s)
+	}
+
+	if s := commandSlot("MULTI", nil); s != -1 {
+		t.Errorf("unexpected slot %d", s)
+	}
+}
+
+func TestPatternTag(t *testing.T) {
+	for pattern, expected := range map[string]string{
+		`ac:{index}:*`:    "index",
+		`ac:{in\*dex}:*`:  "in*dex",
+		`ac:{in\{dex}:*`:  "in{dex",
+		`ac:*:{index}:*`:  "",
+		`ac:{in*dex}:*`:   "",
+		`ac:{}:{index}:*`: "",
+		`ac:index:*`:      "",
+	} {
+		tag, ok := patternTag(pattern)
+		if tag != expected || ok != (expected != "") {
+			t.Errorf("expected %q for %s, got %q", expected, pattern, tag)
+		}
+	}
+}
+
+// fakeNode is a cluster node that serves a range of slots, it records the
+// commands it receives and replies to them without executing them
+type fakeNode struct {
+	addr       string
+	start, end int
+
+	mutex    sync.Mutex
+	commands [][]interface{}
+}
+
+func (n *fakeNode) source(nodes []*fakeNode) *fakeSource {
+	return &fakeSource{handle: func(cmd string,
+		args []interface{}) (interface{}, error) {
+
+		return n.reply(nodes, cmd, args)
+	}}
+}
+
+func (n *fakeNode) reply(nodes []*fakeNode, cmd string,
+	args []interface{}) (interface{}, error) {
+
+	cmd = strings.ToUpper(cmd)
+
+	n.mutex.Lock()
+	n.commands = append(n.commands, append([]interface{}{cmd}, args...))
+	n.mutex.Unlock()
+
+	// a command of a slot of another node is redirected to it
+	if slot := commandSlot(cmd, args); slot >= 0 {
+		for _, other := range nodes {
+			if other != n && slot >= other.start && slot <= other.end {
+				return nil, ReplyError(fmt.Sprintf("MOVED %d %s", slot,
+					other.addr))
+			}
+		}
+	}
+
+	switch cmd {
+	case "CLUSTER":
+		slots := []interface{}{}
+		for _, node := range nodes {
+			parts := strings.Split(node.addr, ":")
+			port, _ := strconv.Atoi(parts[1])
+
+			slots = append(slots, []interface{}{int64(node.start),
+				int64(node.end),
+				[]interface{}{[]byte(parts[0]), int64(port)}})
+		}
+
+		return slots, nil
+
+	case "HGET":
+		return nil, nil
+
+	case "EXEC":
+		return []interface{}{}, nil
+
+	case "EVALSHA", "EVAL":
+		return int64(0), nil
+	}
+
+	return "OK", nil
+}
+
+func TestClusterRouting(t *testing.T) {
+	nodes := []*fakeNode{
+		{addr: "10.0.0.1:7000", start: 0, end: clusterSlots/2 - 1},
+		{addr: "10.0.0.2:7000", start: clusterSlots / 2, end: clusterSlots - 1},
+	}
+
+	sources := make(map[string]ConnSource)
+	for _, n := range nodes {
+		sources[n.addr] = n.source(nodes)
+	}
+
+	c, err := NewCluster([]string{nodes[0].addr},
+		func(addr string) ConnSource {
+			return sources[addr]
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	for _, version := range []int{KeySchemaLegacy, KeySchemaEscaped} {
+		a, err := New(c, "ac", PrefixesIndexing, WithHashTags(),
+			WithKeySchema(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// an index on every node
+		indexes := make([]string, len(nodes))
+		for i := 0; indexes[0] == "" || indexes[1] == ""; i++ {
+			index := "index" + strconv.Itoa(i)
+			slot := keySlot(a.documentsKey(index))
+			for j, n := range nodes {
+				if slot >= n.start && slot <= n.end && indexes[j] == "" {
+					indexes[j] = index
+				}
+			}
+		}
+
+		for _, n := range nodes {
+			n.commands = nil
+		}
+
+		for j, index := range indexes {
+			// all of the keys of an index are in the slot of its tag
+			slot := keySlot(a.documentsKey(index))
+			for _, k := range []string{a.prefixKey(index, "te"),
+				a.termsIndexKey(index), a.termsKey(index), a.idsKey(index),
+				a.expiryKey(index), a.intersectionsKey(index),
+				a.phoneticKey(index, "TST")} {
+
+				if keySlot(k) != slot {
+					t.Fatalf("%s is not in the slot of %s", k, index)
+				}
+			}
+
+			d := doc{DocID: strconv.Itoa(j), Name: "Test " + index}
+			if err := a.Index(index, d, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the commands of every index were sent to the node of its slot
+		for j, n := range nodes {
+			multi := 0
+			indexed := false
+
+			for _, cmd := range n.commands {
+				if cmd[0] == "MULTI" {
+					multi++
+				}
+
+				slot := commandSlot(cmd[0].(string), cmd[1:])
+				if slot >= 0 && (slot < n.start || slot > n.end) {
+					t.Fatalf("%v was sent to %s", cmd, n.addr)
+				}
+
+				if cmd[0] == "ZADD" &&
+					cmd[1] == a.prefixKey(indexes[j], "te") {
+
+					indexed = true
+				}
+			}
+
+			if multi != 1 || !indexed {
+				t.Fatalf("expected the transaction of %s on %s, got %v",
+					indexes[j], n.addr, n.commands)
+			}
+		}
+	}
+}
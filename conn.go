@@ -0,0 +1,156 @@
+package autocomplete
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Conn is a Redis connection that executes the commands of the service, the
+// packages goredis, gomodule and garyburd adapt the connections of Redis
+// clients to it.
+//
+// replies are nil, int64 integers, string status replies, []byte bulk strings,
+// []interface{} arrays and ReplyError error replies. a connection executes all
+// of its commands on a single Redis connection until it is closed, so WATCH
+// and transactions span its calls.
+type Conn interface {
+	// Do executes a command and returns its reply, an error reply is
+	// returned as the error
+	Do(cmd string, args ...interface{}) (interface{}, error)
+
+	// Pipeline executes commands in a single round trip and returns their
+	// replies in order, error replies are returned as replies and the error
+	// is only set when the commands could not be executed
+	Pipeline(cmds []Command) ([]interface{}, error)
+
+	// Close releases the connection
+	Close() error
+}
+
+// Command is a Redis command and its arguments
+type Command struct {
+	Name string
+	Args []interface{}
+}
+
+// ConnSource is a source of Redis connections. *Cluster, *Sentinel and
+// *Replicas are connection sources, and the goredis, gomodule and garyburd
+// packages adapt go-redis clients and redigo pools.
+type ConnSource interface {
+	Get() Conn
+}
+
+// Subscriber is implemented by connection sources that subscribe to Pub/Sub
+// channels, it is required to receive the cache invalidations of other
+// processes
+type Subscriber interface {
+	Subscribe(channel string) (Subscription, error)
+}
+
+// Subscription is a subscription to a Pub/Sub channel
+type Subscription interface {
+	// Receive waits for the next message of the channel and returns its
+	// payload
+	Receive() ([]byte, error)
+
+	// Close ends the subscription, a blocked Receive returns an error
+	Close() error
+}
+
+// redisConn queues the commands sent on a connection and executes them in a
+// pipeline when it is flushed, their replies are received in the order the
+// commands were sent
+type redisConn struct {
+	Conn
+	pending []Command
+	replies []interface{}
+}
+
+func newRedisConn(conn Conn) *redisConn {
+	return &redisConn{Conn: conn}
+}
+
+// Send queues a command
+func (c *redisConn) Send(cmd string, args ...interface{}) error {
+	c.pending = append(c.pending, Command{Name: cmd, Args: args})
+	return nil
+}
+
+// Flush executes the queued commands
+func (c *redisConn) Flush() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	cmds := c.pending
+	c.pending = nil
+
+	replies, err := c.Conn.Pipeline(cmds)
+	if err != nil {
+		return err
+	}
+
+	if len(replies) != len(cmds) {
+		return fmt.Errorf("%d replies of %d commands", len(replies),
+			len(cmds))
+	}
+
+	c.replies = append(c.replies, replies...)
+	return nil
+}
+
+// Receive returns the reply of the first sent command whose reply was not
+// received, the commands are flushed first if needed
+func (c *redisConn) Receive() (interface{}, error) {
+	if len(c.replies) == 0 {
+		if err := c.Flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.replies) == 0 {
+		return nil, errors.New("no pending replies")
+	}
+
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+
+	if err, ok := reply.(ReplyError); ok {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// Do executes a command after the sent commands, it returns the reply of the
+// command and the first error reply. with an empty command it returns the
+// replies of all of the sent commands, error replies included.
+func (c *redisConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" && len(c.pending) == 0 && len(c.replies) == 0 {
+		return c.Conn.Do(cmd, args...)
+	}
+
+	if cmd != "" {
+		c.Send(cmd, args...)
+	}
+
+	if err := c.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := c.replies
+	c.replies = nil
+
+	if cmd == "" {
+		return replies, nil
+	}
+
+	var err error
+	for _, r := range replies {
+		if e, ok := r.(ReplyError); ok && err == nil {
+			err = e
+		}
+	}
+
+	return replies[len(replies)-1], err
+}
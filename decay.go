@@ -0,0 +1,138 @@
+package autocomplete
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// SetDecay enables time decayed scoring for an index, the score of a document
+// is halved for every halfLife that passed since its last activity.
+//
+// the last activity of a document is recorded when it is indexed, when its
+// score is updated and by Touch. score sorted searches rank documents by
+// their decayed scores computed at query time, documents with no recorded
+// activity decay from the time decay was first used for the index, which is
+// stored so every process decays them alike.
+//
+// SetDecay should be called before the service is used.
+func (a *Autocomplete) SetDecay(index string, halfLife time.Duration) {
+	if halfLife <= 0 {
+		delete(a.halfLives, index)
+		return
+	}
+
+	a.halfLives[index] = halfLife
+}
+
+// Touch records activity of a document at the given time without changing its
+// score
+func (a *Autocomplete) Touch(index string, d Document, at time.Time) error {
+	l, err := a.layout(index)
+	if err != nil {
+		return err
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	if _, err := conn.Do(
+		"HSET", a.activityKey(index), l.docKey(d), at.Unix()); err != nil {
+
+		return err
+	}
+
+	return a.invalidateResults(conn, index)
+}
+
+// activityKey is the key of the hash that stores the last activity unix time
+// of every document of an index
+func (a *Autocomplete) activityKey(index string) string {
+	return a.indexKey(activityKind, index)
+}
+
+// decayStartKey is the key of the unix time decay was first used for an index
+func (a *Autocomplete) decayStartKey(index string) string {
+	return a.indexKey(decayStartKind, index)
+}
+
+// decayStart returns the time decay was first used for an index, it is stored
+// by the first process that uses it
+func (a *Autocomplete) decayStart(index string) (time.Time, error) {
+	conn := a.conn()
+	defer conn.Close()
+
+	if _, err := conn.Do("SETNX", a.decayStartKey(index),
+		time.Now().Unix()); err != nil {
+
+		return time.Time{}, err
+	}
+
+	unix, err := replyInt64(conn.Do("GET", a.decayStartKey(index)))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(unix, 0), nil
+}
+
+// decay returns the score decayed by the number of half lives that passed
+// since the last activity
+func decay(score float64, lastActivity, now time.Time,
+	halfLife time.Duration) float64 {
+
+	age := now.Sub(lastActivity)
+	if age <= 0 {
+		return score
+	}
+
+	return score * math.Pow(0.5, float64(age)/float64(halfLife))
+}
+
+// decayedScores returns the stored scores of the given document keys,
+// decayed when decay is enabled for the index
+func (a *Autocomplete) decayedScores(index string,
+	keys []string) ([]float64, error) {
+
+	scores, err := a.scores(index, keys)
+	if err != nil {
+		return []float64{}, err
+	}
+
+	halfLife, ok := a.halfLives[index]
+	if !ok {
+		return scores, nil
+	}
+
+	values, err := a.hmget(a.activityKey(index), keys)
+	if err != nil {
+		return []float64{}, err
+	}
+
+	now := time.Now()
+	start := time.Time{}
+	for i, v := range values {
+		s, err := replyString(v, nil)
+		if err == errNil {
+			if start.IsZero() {
+				if start, err = a.decayStart(index); err != nil {
+					return []float64{}, err
+				}
+			}
+
+			scores[i] = decay(scores[i], start, now, halfLife)
+			continue
+		} else if err != nil {
+			return []float64{}, err
+		}
+
+		unix, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return []float64{}, err
+		}
+
+		scores[i] = decay(scores[i], time.Unix(unix, 0), now, halfLife)
+	}
+
+	return scores, nil
+}
@@ -0,0 +1,28 @@
+package autocomplete
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecay(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	if decay(100, now, now, day) != 100 {
+		t.Fail()
+	}
+
+	if decay(100, now.Add(-day), now, day) != 50 {
+		t.Fail()
+	}
+
+	if decay(100, now.Add(-2*day), now, day) != 25 {
+		t.Fail()
+	}
+
+	// activity in the future is not decayed
+	if decay(100, now.Add(day), now, day) != 100 {
+		t.Fail()
+	}
+}
@@ -13,20 +13,73 @@ type Document interface {
 	Data() interface{}
 }
 
+// key returns the key of a document in the legacy key schema, keys of
+// different terms and IDs collide when they contain underscores
 func key(d Document) string {
-	return strings.Replace(strings.ToLower(d.Term()), " ", "_", -1) + "_" + d.ID()
+	return buildDocKey(KeySchemaLegacy, d.Term(), d.ID())
 }
 
-func prefixes(d Document) []string {
+// docKey returns the key of a document in an index, indexes migrated from the
+// legacy key schema keep their legacy document keys
+func (l layout) docKey(d Document) string {
+	return buildDocKey(l.docKeys, d.Term(), d.ID())
+}
+
+// buildDocKey builds a document key in a key schema, escaped keys are the
+// escaped term and ID separated by a colon
+func buildDocKey(version int, term, id string) string {
+	term = strings.ToLower(term)
+
+	if version == KeySchemaLegacy {
+		return strings.Replace(term, " ", "_", -1) + "_" + id
+	}
+
+	return keyEscaper.Replace(term) + ":" + keyEscaper.Replace(id)
+}
+
+// keyID returns the document ID of a document key generated from the given
+// term in a key schema
+func keyID(version int, docKey, term string) (string, bool) {
+	p := buildDocKey(version, term, "")
+	if !strings.HasPrefix(docKey, p) {
+		return "", false
+	}
+
+	if version == KeySchemaLegacy {
+		return docKey[len(p):], true
+	}
+
+	return keyUnescaper.Replace(docKey[len(p):]), true
+}
+
+// keyTerm returns the term of a document that was indexed without storing its
+// term, legacy keys only approximate it with the ID included
+func keyTerm(version int, docKey string) string {
+	if version == KeySchemaLegacy {
+		return strings.Replace(docKey, "_", " ", -1)
+	}
+
+	return keyUnescaper.Replace(strings.SplitN(docKey, ":", 2)[0])
+}
+
+func (a *Autocomplete) prefixes(d Document) []string {
+	return a.termPrefixes(d.Term())
+}
+
+// termPrefixes returns the prefixes of the words of a term
+func (a *Autocomplete) termPrefixes(term string) []string {
+	return wordPrefixes(a.analyze(term))
+}
+
+func wordPrefixes(words []string) []string {
 	p := []string{}
 
-	terms := strings.Split(d.Term(), " ")
-	for _, t := range terms {
-		for i := range strings.ToLower(t) {
+	for _, w := range words {
+		for i := range w {
 			buf := bytes.NewBuffer([]byte{})
 
 			for j := 0; j <= i; j++ {
-				buf.WriteByte(strings.ToLower(t)[j])
+				buf.WriteByte(w[j])
 			}
 
 			p = appendUnique(p, buf.String())
@@ -42,7 +42,9 @@ func TestPrefixes(t *testing.T) {
 		DocData: "dbID123",
 	}
 
-	if !reflect.DeepEqual(prefixes(d),
+	a := &Autocomplete{analyzer: DefaultAnalyzer}
+
+	if !reflect.DeepEqual(a.prefixes(d),
 		[]string{"t", "te", "tes", "test", "s", "se", "sea", "sear", "searc",
 			"search", "ter", "term", "term!"}) {
 
@@ -63,3 +65,65 @@ func TestAppendUnique(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestDocKey(t *testing.T) {
+	d1 := doc{DocID: "c", Name: "A b"}
+	d2 := doc{DocID: "b_c", Name: "a"}
+
+	// legacy keys of different documents collide
+	if key(d1) != key(d2) {
+		t.Fail()
+	}
+
+	l := layout{docKeys: KeySchemaEscaped}
+	if l.docKey(d1) == l.docKey(d2) {
+		t.Errorf("the keys of %+v and %+v collide", d1, d2)
+	}
+
+	d3 := doc{DocID: "1:2", Name: "a"}
+	d4 := doc{DocID: "2", Name: "a:1"}
+	if l.docKey(d3) == l.docKey(d4) {
+		t.Errorf("the keys of %+v and %+v collide", d3, d4)
+	}
+
+	if k := l.docKey(d1); k != "a b:c" {
+		t.Errorf("unexpected key %s", k)
+	}
+
+	if term := keyTerm(KeySchemaEscaped, l.docKey(d4)); term != "a:1" {
+		t.Errorf("expected the term a:1, got %s", term)
+	}
+
+	if k := routingKey(KeySchemaEscaped, l.docKey(d1)); k != key(d1) {
+		t.Errorf("expected the routing key %s, got %s", key(d1), k)
+	}
+
+	l = layout{docKeys: KeySchemaLegacy}
+	if l.docKey(d1) != key(d1) {
+		t.Fail()
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	d := doc{
+		DocID:   "1_23",
+		Name:    "Test SEARCH term!",
+		DocData: "dbID123",
+	}
+
+	for _, version := range []int{KeySchemaLegacy, KeySchemaEscaped} {
+		docKey := buildDocKey(version, d.Term(), d.ID())
+		if id, ok := keyID(version, docKey, d.Term()); !ok || id != "1_23" {
+			t.Errorf("unexpected ID %s of %s", id, docKey)
+		}
+
+		if _, ok := keyID(version, docKey, "other term"); ok {
+			t.Errorf("unexpected ID of %s", docKey)
+		}
+	}
+
+	docKey := buildDocKey(KeySchemaEscaped, "a", "1:2")
+	if id, ok := keyID(KeySchemaEscaped, docKey, "a"); !ok || id != "1:2" {
+		t.Errorf("expected the ID 1:2, got %s", id)
+	}
+}
@@ -0,0 +1,120 @@
+package autocomplete
+
+import (
+	"errors"
+)
+
+// Error is a failure of the service, Kind is one of the error objects and
+// Index and Key are the index and the document key or ID it concerns if any
+type Error struct {
+	Kind  error
+	Index string
+	Key   string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	s := e.Kind.Error()
+	if e.Index != "" {
+		s += ": index " + e.Index
+	}
+
+	if e.Key != "" {
+		s += ": key " + e.Key
+	}
+
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+
+	return s
+}
+
+// Is returns whether the error is of the kind of target
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// notFound returns the error of a document key or ID that is not in an index,
+// the cause may be nil
+func (a *Autocomplete) notFound(conn *redisConn, index, key string,
+	cause error) error {
+
+	exists, err := replyBool(conn.Do("EXISTS", a.documentsKey(index)))
+	if err != nil {
+		return err
+	}
+
+	kind := ErrDocumentNotFound
+	if !exists {
+		kind = ErrIndexNotFound
+	}
+
+	return &Error{Kind: kind, Index: index, Key: key, Err: cause}
+}
+
+// checkIndexed returns the error of a document key that is not in an index
+func (a *Autocomplete) checkIndexed(conn *redisConn, index,
+	docKey string) error {
+
+	exists, err := replyBool(conn.Do("HEXISTS", a.documentsKey(index),
+		docKey))
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return a.notFound(conn, index, docKey, nil)
+	}
+
+	return nil
+}
+
+// conn returns a connection of the primary connection source
+func (a *Autocomplete) conn() *redisConn {
+	return newRedisConn(availabilityConn{a.pool.Get()})
+}
+
+// readConn returns a connection of the read connection source
+func (a *Autocomplete) readConn() *redisConn {
+	return newRedisConn(availabilityConn{a.reads.Get()})
+}
+
+// availabilityConn is a connection whose failures to reach Redis are
+// ErrBackendUnavailable, error replies are returned as they are
+type availabilityConn struct {
+	Conn
+}
+
+func (c availabilityConn) Do(cmd string,
+	args ...interface{}) (interface{}, error) {
+
+	reply, err := c.Conn.Do(cmd, args...)
+	return reply, unavailable(err)
+}
+
+func (c availabilityConn) Pipeline(cmds []Command) ([]interface{}, error) {
+	replies, err := c.Conn.Pipeline(cmds)
+	return replies, unavailable(err)
+}
+
+func unavailable(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(ReplyError); ok {
+		return err
+	}
+
+	if errors.Is(err, ErrBackendUnavailable) {
+		return err
+	}
+
+	return &Error{Kind: ErrBackendUnavailable, Err: err}
+}
@@ -0,0 +1,47 @@
+package autocomplete
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	cause := errors.New("cause")
+	err := error(&Error{Kind: ErrDocumentNotFound, Index: "idx", Key: "k",
+		Err: cause})
+
+	if !errors.Is(err, ErrDocumentNotFound) || errors.Is(err, ErrIndexNotFound) {
+		t.Fatalf("unexpected kind of %v", err)
+	}
+
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected %v to wrap its cause", err)
+	}
+
+	if s := err.Error(); s != "document not found: index idx: key k: cause" {
+		t.Fatalf("unexpected message %q", s)
+	}
+}
+
+func TestAvailabilityConn(t *testing.T) {
+	network := errors.New("connection reset")
+
+	_, err := availabilityConn{fakeConn{&fakeSource{err: network}}}.Do("PING")
+	if !errors.Is(err, ErrBackendUnavailable) || !errors.Is(err, network) {
+		t.Fatalf("expected an unavailable backend, got %v", err)
+	}
+
+	// wrapped errors are not wrapped again
+	_, err = availabilityConn{fakeConn{&fakeSource{err: err}}}.Do("PING")
+	if e := err.(*Error); e.Err != network {
+		t.Fatalf("expected the error wrapped once, got %v", err)
+	}
+
+	// error replies are returned as they are
+	reply := ReplyError("ERR wrong number of arguments")
+	conn := availabilityConn{fakeConn{&fakeSource{err: reply}}}
+	if _, err := conn.Do("PING"); err != reply {
+
+		t.Fatalf("expected the error reply, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package autocomplete_test
+
+import (
+	"log"
+	"time"
+
+	"github.com/augurysys/autocomplete"
+	"github.com/augurysys/autocomplete/garyburd"
+	"github.com/garyburd/redigo/redis"
+)
+
+type car struct {
+	DocID string `json:"id"`
+	Name  string `json:"name"`
+}
+
+func (c car) ID() string {
+	return c.DocID
+}
+
+func (c car) Term() string {
+	return c.Name
+}
+
+func (c car) Data() interface{} {
+	return nil
+}
+
+func newPool() *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     3,
+		MaxActive:   20,
+		IdleTimeout: 240 * time.Second,
+		Wait:        true,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", "localhost:6379")
+			if err != nil {
+				return nil, err
+			}
+
+			return c, err
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}
+
+func ExampleNew() {
+	pool := newPool()
+	defer pool.Close()
+
+	_, err := autocomplete.New(garyburd.NewSource(pool), "ac",
+		autocomplete.PrefixesIndexing, autocomplete.WithBatchSize(500))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,221 @@
+package autocomplete
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// sweepBatch is the number of expired documents removed in every iteration
+// of a sweep
+const sweepBatch = 1000
+
+// expiryKey is the key of the ZSET of expiring documents scored by their
+// expiry unix time
+func (a *Autocomplete) expiryKey(index string) string {
+	return a.indexKey(expiryKind, index)
+}
+
+// expired returns the keys of the documents of an index that expired
+func (a *Autocomplete) expired(index string) (map[string]bool, error) {
+	conn := a.readConn()
+	defer conn.Close()
+
+	keys, err := replyStrings(conn.Do("ZRANGEBYSCORE", a.expiryKey(index),
+		"-inf", time.Now().Unix()))
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make(map[string]bool)
+	for _, k := range keys {
+		expired[k] = true
+	}
+
+	return expired, nil
+}
+
+// expiresAt returns the expiry time of a document key, or the zero time when
+// the document does not expire
+func (a *Autocomplete) expiresAt(conn *redisConn, index,
+	docKey string) (time.Time, error) {
+
+	unix, err := replyInt64(conn.Do("ZSCORE", a.expiryKey(index), docKey))
+	if err == errNil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(unix, 0), nil
+}
+
+// withoutExpired returns the document keys that did not expire
+func (a *Autocomplete) withoutExpired(index string,
+	keys []string) ([]string, error) {
+
+	if len(keys) == 0 {
+		return keys, nil
+	}
+
+	expired, err := a.expired(index)
+	if err != nil {
+		return []string{}, err
+	}
+
+	if len(expired) == 0 {
+		return keys, nil
+	}
+
+	results := []string{}
+	for _, k := range keys {
+		if !expired[k] {
+			results = append(results, k)
+		}
+	}
+
+	return results, nil
+}
+
+// SweepExpired removes the expired documents of an index from all of its
+// ZSETs and hashes, it returns the number of removed documents. expiry entries
+// of documents that are no longer stored or indexed are dropped.
+func (a *Autocomplete) SweepExpired(index string) (int, error) {
+	removed := 0
+
+	for {
+		conn := a.conn()
+		keys, err := replyStrings(conn.Do("ZRANGEBYSCORE", a.expiryKey(index),
+			"-inf", time.Now().Unix(), "LIMIT", 0, sweepBatch))
+		conn.Close()
+
+		if err != nil {
+			return removed, err
+		}
+
+		if len(keys) == 0 {
+			return removed, nil
+		}
+
+		terms, err := a.primary().storedTerms(index, keys)
+		if err != nil {
+			return removed, err
+		}
+
+		for i, k := range keys {
+			err := a.removeKey(index, k, terms[i])
+			if errors.Is(err, ErrDocumentNotFound) ||
+				errors.Is(err, ErrIndexNotFound) {
+
+				// the entry of a document that was already removed
+				if err := a.removeExpiryEntry(index, k); err != nil {
+					return removed, err
+				}
+
+				continue
+			} else if err != nil {
+				return removed, err
+			}
+
+			removed++
+		}
+	}
+}
+
+// removeExpiryEntry removes the expiry entry of an expired document that
+// could not be removed, with its stored document and term, so that sweeps do
+// not find it again. the entry is kept if the document got a new expiry.
+func (a *Autocomplete) removeExpiryEntry(index, docKey string) error {
+	conn := a.conn()
+	defer conn.Close()
+
+	for {
+		if _, err := conn.Do("WATCH", a.expiryKey(index)); err != nil {
+			return err
+		}
+
+		expiry, err := replyFloat64(conn.Do("ZSCORE", a.expiryKey(index),
+			docKey))
+		if err == errNil || err == nil && expiry > float64(time.Now().Unix()) {
+			_, err := conn.Do("UNWATCH")
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		if err := conn.Send("MULTI"); err != nil {
+			return err
+		}
+
+		if err := conn.Send("ZREM", a.expiryKey(index), docKey); err != nil {
+			return err
+		}
+
+		if err := conn.Send("HDEL", a.documentsKey(index), docKey); err != nil {
+			return err
+		}
+
+		if err := conn.Send("HDEL", a.termsKey(index), docKey); err != nil {
+			return err
+		}
+
+		reply, err := conn.Do("EXEC")
+		if err := replyError(reply, err); err != nil {
+			return err
+		}
+
+		// retried when the expiry changed during the transaction
+		if reply != nil {
+			return nil
+		}
+	}
+}
+
+// Sweeper removes expired documents in the background
+type Sweeper struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// StartSweeper starts sweeping the expired documents of the given indexes
+// every interval, sweep errors are passed to onError if it is not nil,
+// otherwise they are logged
+func (a *Autocomplete) StartSweeper(interval time.Duration,
+	onError func(error), indexes ...string) *Sweeper {
+
+	s := &Sweeper{stop: make(chan struct{})}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+
+			case <-ticker.C:
+				for _, index := range indexes {
+					_, err := a.SweepExpired(index)
+					if err != nil && onError != nil {
+						onError(err)
+					} else if err != nil {
+						a.logf("autocomplete: sweeping %s failed: %v",
+							index, err)
+					}
+				}
+			}
+		}
+	}()
+
+	return s
+}
+
+// Stop stops the sweeper and waits for a running sweep to finish
+func (s *Sweeper) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
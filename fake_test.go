@@ -0,0 +1,53 @@
+package autocomplete
+
+// fakeSource is a source of fake connections, which reply to every command
+// with the reply of handle, or with the name and the error of the source when
+// it has no handler
+type fakeSource struct {
+	name   string
+	err    error
+	handle func(cmd string, args []interface{}) (interface{}, error)
+}
+
+func (s *fakeSource) Get() Conn {
+	return fakeConn{s}
+}
+
+// conn returns a pipelining connection of the source, like the connections of
+// services
+func (s *fakeSource) conn() *redisConn {
+	return newRedisConn(s.Get())
+}
+
+// fakeConn is a connection of a fake source
+type fakeConn struct {
+	source *fakeSource
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if c.source.handle != nil {
+		return c.source.handle(cmd, args)
+	}
+
+	return c.source.name, c.source.err
+}
+
+func (c fakeConn) Pipeline(cmds []Command) ([]interface{}, error) {
+	replies := []interface{}{}
+	for _, cmd := range cmds {
+		reply, err := c.Do(cmd.Name, cmd.Args...)
+		if e, ok := err.(ReplyError); ok {
+			reply = e
+		} else if err != nil {
+			return nil, err
+		}
+
+		replies = append(replies, reply)
+	}
+
+	return replies, nil
+}
@@ -0,0 +1,150 @@
+// Package garyburd adapts a pool of github.com/garyburd/redigo, the import
+// path of redigo before it moved to github.com/gomodule/redigo, to a
+// connection source of autocomplete services.
+package garyburd
+
+import (
+	"errors"
+
+	"github.com/augurysys/autocomplete"
+	"github.com/garyburd/redigo/redis"
+)
+
+var errUnsubscribed = errors.New("garyburd: unsubscribed")
+
+// Source is a connection source backed by a redigo pool, subscriptions dial
+// their own connections with the pool's Dial function
+type Source struct {
+	pool *redis.Pool
+}
+
+// NewSource returns a connection source for a redigo pool
+func NewSource(pool *redis.Pool) *Source {
+	return &Source{pool: pool}
+}
+
+// Get returns a connection from the pool, the connection must be closed
+func (s *Source) Get() autocomplete.Conn {
+	return conn{s.pool.Get()}
+}
+
+// Close closes the pool
+func (s *Source) Close() error {
+	return s.pool.Close()
+}
+
+// Subscribe subscribes to a channel on a new connection, it returns once the
+// subscription is confirmed. pools without a Dial function fail with
+// autocomplete.ErrPubSubUnsupported.
+func (s *Source) Subscribe(channel string) (autocomplete.Subscription, error) {
+	if s.pool.Dial == nil {
+		return nil, autocomplete.ErrPubSubUnsupported
+	}
+
+	c, err := s.pool.Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	psc := redis.PubSubConn{Conn: c}
+	if err := psc.Subscribe(channel); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	for {
+		switch m := psc.Receive().(type) {
+		case redis.Subscription:
+			return subscription{psc}, nil
+
+		case error:
+			c.Close()
+			return nil, m
+		}
+	}
+}
+
+type subscription struct {
+	psc redis.PubSubConn
+}
+
+func (s subscription) Receive() ([]byte, error) {
+	for {
+		switch m := s.psc.Receive().(type) {
+		case redis.Message:
+			return m.Data, nil
+
+		case redis.Subscription:
+			if m.Count == 0 {
+				return nil, errUnsubscribed
+			}
+
+		case error:
+			return nil, m
+		}
+	}
+}
+
+func (s subscription) Close() error {
+	return s.psc.Close()
+}
+
+// conn converts the replies of a redigo connection
+type conn struct {
+	redis.Conn
+}
+
+func (c conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return reply(c.Conn.Do(cmd, args...))
+}
+
+func (c conn) Pipeline(cmds []autocomplete.Command) ([]interface{}, error) {
+	for _, cmd := range cmds {
+		if err := c.Conn.Send(cmd.Name, cmd.Args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		r, err := reply(c.Conn.Receive())
+		if e, ok := err.(autocomplete.ReplyError); ok {
+			r = e
+		} else if err != nil {
+			return nil, err
+		}
+
+		replies[i] = r
+	}
+
+	return replies, nil
+}
+
+func reply(v interface{}, err error) (interface{}, error) {
+	if e, ok := err.(redis.Error); ok {
+		err = autocomplete.ReplyError(e)
+	}
+
+	return value(v), err
+}
+
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case redis.Error:
+		return autocomplete.ReplyError(v)
+
+	case []interface{}:
+		for i, e := range v {
+			v[i] = value(e)
+		}
+
+		return v
+
+	default:
+		return v
+	}
+}
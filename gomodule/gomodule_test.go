@@ -0,0 +1,24 @@
+package gomodule
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/augurysys/autocomplete"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestReply(t *testing.T) {
+	v, err := reply([]interface{}{[]byte("a"), redis.Error("ERR wrong type")},
+		redis.Error("EXECABORT"))
+
+	if err != autocomplete.ReplyError("EXECABORT") {
+		t.Fatalf("expected a reply error, got %#v", err)
+	}
+
+	expected := []interface{}{[]byte("a"),
+		autocomplete.ReplyError("ERR wrong type")}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, v)
+	}
+}
@@ -0,0 +1,167 @@
+// Package goredis adapts a github.com/redis/go-redis/v9 client to a connection
+// source of autocomplete services, so a service shares the client's
+// connection pool, hooks and instrumentation.
+//
+// the client must use the RESP2 protocol, whose replies have the types of the
+// replies of autocomplete connections.
+package goredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/augurysys/autocomplete"
+	"github.com/redis/go-redis/v9"
+)
+
+var errClosed = errors.New("goredis: connection closed")
+
+// Source is a connection source backed by a go-redis client, every connection
+// executes its commands on a single connection of the client's pool
+type Source struct {
+	client *redis.Client
+}
+
+// NewSource returns a connection source for a go-redis client, the client's
+// options must set Protocol to 2
+func NewSource(client *redis.Client) (*Source, error) {
+	if p := client.Options().Protocol; p != 2 {
+		return nil, fmt.Errorf("goredis: unsupported protocol %d", p)
+	}
+
+	return &Source{client: client}, nil
+}
+
+// Get returns a connection, the connection must be closed to return the
+// client's connection to its pool
+func (s *Source) Get() autocomplete.Conn {
+	return &conn{client: s.client}
+}
+
+// conn executes commands on a connection of the client's pool, which is taken
+// from the pool on first use so WATCH and transactions span its calls
+type conn struct {
+	client *redis.Client
+	conn   *redis.Conn
+	closed bool
+}
+
+func (c *conn) sticky() *redis.Conn {
+	if c.conn == nil {
+		c.conn = c.client.Conn()
+	}
+
+	return c.conn
+}
+
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
+
+func (c *conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if c.closed {
+		return nil, errClosed
+	}
+
+	ctx := context.Background()
+
+	result := redis.NewCmd(ctx, append([]interface{}{cmd}, args...)...)
+	c.sticky().Process(ctx, result)
+
+	v, err := result.Result()
+	if failed(err) {
+		return nil, err
+	}
+
+	r := reply(v, err)
+	if e, ok := r.(autocomplete.ReplyError); ok {
+		return nil, e
+	}
+
+	return r, nil
+}
+
+func (c *conn) Pipeline(cmds []autocomplete.Command) ([]interface{}, error) {
+	if c.closed {
+		return nil, errClosed
+	}
+
+	ctx := context.Background()
+
+	results := make([]*redis.Cmd, len(cmds))
+	_, err := c.sticky().Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, cmd := range cmds {
+			results[i] = p.Do(ctx, append([]interface{}{cmd.Name},
+				cmd.Args...)...)
+		}
+
+		return nil
+	})
+
+	if failed(err) {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(results))
+	for i, r := range results {
+		replies[i] = reply(r.Result())
+	}
+
+	return replies, nil
+}
+
+// failed reports whether an error is a failure to execute commands rather
+// than an error or a nil reply
+func failed(err error) bool {
+	if err == nil || err == redis.Nil {
+		return false
+	}
+
+	_, ok := err.(redis.Error)
+	return !ok
+}
+
+// reply converts the result of a go-redis command to a reply of autocomplete
+// connections
+func reply(v interface{}, err error) interface{} {
+	if err == redis.Nil {
+		return nil
+	}
+
+	if err != nil {
+		return autocomplete.ReplyError(err.Error())
+	}
+
+	return value(v)
+}
+
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = value(e)
+		}
+
+		return values
+
+	case error:
+		return autocomplete.ReplyError(v.Error())
+
+	default:
+		return v
+	}
+}
@@ -0,0 +1,46 @@
+package goredis
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/augurysys/autocomplete"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestReply(t *testing.T) {
+	tests := []struct {
+		v        interface{}
+		err      error
+		expected interface{}
+	}{
+		{"OK", nil, []byte("OK")},
+		{int64(3), nil, int64(3)},
+		{nil, redis.Nil, nil},
+		{nil, errors.New("ERR wrong type"),
+			autocomplete.ReplyError("ERR wrong type")},
+		{[]interface{}{"a", nil, int64(1), []interface{}{"b"}}, nil,
+			[]interface{}{[]byte("a"), nil, int64(1),
+				[]interface{}{[]byte("b")}}},
+	}
+
+	for _, test := range tests {
+		r := reply(test.v, test.err)
+		if !reflect.DeepEqual(r, test.expected) {
+			t.Fatalf("expected %#v, got %#v", test.expected, r)
+		}
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	if _, err := NewSource(redis.NewClient(&redis.Options{})); err == nil {
+		t.Fatal("expected an error for the RESP3 protocol")
+	}
+
+	if _, err := NewSource(redis.NewClient(&redis.Options{
+		Protocol: 2,
+	})); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,150 @@
+// +build integration
+
+package goredis
+
+import (
+	"encoding/json"
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/augurysys/autocomplete"
+	"github.com/redis/go-redis/v9"
+)
+
+var redisURL string
+var redisPassword string
+
+func init() {
+	url := flag.String("redis_url", "localhost:6379", "Redis URL")
+	password := flag.String("redis_password", "", "Redis password")
+
+	flag.Parse()
+
+	redisURL = *url
+	redisPassword = *password
+}
+
+type doc struct {
+	DocID string `json:"id"`
+	Name  string `json:"name"`
+}
+
+func (d doc) ID() string {
+	return d.DocID
+}
+
+func (d doc) Term() string {
+	return d.Name
+}
+
+func (d doc) Data() interface{} {
+	return nil
+}
+
+func newSource(t *testing.T) (*Source, func()) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     redisURL,
+		Password: redisPassword,
+		Protocol: 2,
+	})
+
+	source, err := NewSource(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return source, func() { client.Close() }
+}
+
+func TestSource(t *testing.T) {
+	source, closeSource := newSource(t)
+	defer closeSource()
+
+	for _, indexType := range []int{autocomplete.PrefixesIndexing,
+		autocomplete.TermsIndexing} {
+
+		conn := source.Get()
+		if _, err := conn.Do("FLUSHALL"); err != nil {
+			t.Fatal(err)
+		}
+
+		conn.Close()
+
+		a, err := autocomplete.New(source, "ac", indexType,
+			autocomplete.WithCache(10, time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// go-redis connections do not receive Pub/Sub messages
+		if _, err := a.StartInvalidator(nil); err !=
+			autocomplete.ErrPubSubUnsupported {
+
+			t.Fatalf("expected ErrPubSubUnsupported, got %v", err)
+		}
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Test one",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Test two",
+		}
+
+		// transactions, pipelines and scripts
+		if err := a.Index("test_index", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := a.Upsert("test_index", d2, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := a.RecordSelection("test_index", "test", d1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := a.UpdateScore("test_index", d2, 5); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := a.Search("test_index", "test",
+			autocomplete.SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		docs := []doc{}
+		for _, r := range results {
+			var d doc
+			if err := json.Unmarshal(r, &d); err != nil {
+				t.Fatal(err)
+			}
+
+			docs = append(docs, d)
+		}
+
+		expected := []doc{d2, d1}
+		if !reflect.DeepEqual(docs, expected) {
+			t.Fatalf("expected %+v, got %+v", expected, docs)
+		}
+
+		if err := a.RemoveDocument("test_index", d1); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err = a.Search("test_index", "test one",
+			autocomplete.SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 0 {
+			t.Fatalf("expected 0 results, got %d", len(results))
+		}
+	}
+}
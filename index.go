@@ -5,59 +5,99 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
-	"fmt"
-	"strings"
-
-	"github.com/garyburd/redigo/redis"
+	"errors"
+	"time"
 )
 
 // Index indexes a document for autocomplete search
 func (a *Autocomplete) Index(index string, d Document, score uint64) error {
-	conn := a.pool.Get()
+	return a.IndexWithExpiry(index, d, score, time.Time{})
+}
+
+// IndexWithExpiry indexes a document for autocomplete search until the given
+// expiry time, a zero expiry time never expires.
+//
+// expired documents are omitted from search results and removed from the
+// index by SweepExpired
+func (a *Autocomplete) IndexWithExpiry(index string, d Document, score uint64,
+	expiresAt time.Time) error {
+
+	conn := a.conn()
 	defer conn.Close()
 
-	docKey := key(d)
-	b, err := json.Marshal(d)
+	l, err := a.layout(index)
 	if err != nil {
 		return err
 	}
 
-	switch a.indexType {
-	case PrefixesIndexing:
-		if err := conn.Send("MULTI"); err != nil {
-			return err
-		}
+	docKey := l.docKey(d)
 
-		for _, p := range prefixes(d) {
-			if err := conn.Send("ZADD", a.prefix+":"+index+":"+p,
-				score, docKey); err != nil {
-
-				return err
-			}
-		}
+	switch l.indexType {
+	case PrefixesIndexing:
+		// an indexed document is re-indexed with the new score
 
 	case TermsIndexing:
-		exists, err := redis.Bool(conn.Do("HEXISTS", a.prefix+":$"+index, docKey))
+		exists, err := replyBool(conn.Do("HEXISTS", a.documentsKey(index),
+			docKey))
 		if err != nil {
 			return err
 		}
 
 		if exists {
-			return fmt.Errorf("%+v is already indexed in %s",
-				d, index)
+			return &Error{Kind: ErrAlreadyIndexed, Index: index, Key: docKey}
 		}
 
-		scoreStr, err := scoreString(score)
-		if err != nil {
-			return err
+	default:
+		return ErrInvalidIndexType
+	}
+
+	if err := a.recordKeySchema(conn); err != nil {
+		return err
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+
+	if err := a.sendIndex(conn, l, index, d, score, expiresAt); err != nil {
+		return err
+	}
+
+	if err := replyError(conn.Do("EXEC")); err != nil {
+		return err
+	}
+
+	return a.invalidate(conn, index)
+}
+
+// sendIndex queues the commands that index a document in a layout on a
+// connection
+func (a *Autocomplete) sendIndex(conn *redisConn, l layout, index string,
+	d Document, score uint64, expiresAt time.Time) error {
+
+	docKey := l.docKey(d)
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	switch l.indexType {
+	case PrefixesIndexing:
+		for _, p := range a.prefixes(d) {
+			if err := conn.Send("ZADD", a.prefixKey(index, p),
+				score, docKey); err != nil {
+
+				return err
+			}
 		}
 
-		if err := conn.Send("MULTI"); err != nil {
+	case TermsIndexing:
+		val, err := a.encodeMember(d.Term(), score, docKey)
+		if err != nil {
 			return err
 		}
 
-		val := strings.ToLower(d.Term()) + "::" + scoreStr + "::" + docKey
-		if err := conn.Send("ZADD", a.prefix+":$$"+index, 0, val); err != nil {
+		if err := conn.Send("ZADD", a.termsIndexKey(index), 0, val); err != nil {
 			return err
 		}
 
@@ -65,74 +105,201 @@ func (a *Autocomplete) Index(index string, d Document, score uint64) error {
 		return ErrInvalidIndexType
 	}
 
+	if a.phonetic {
+		for _, k := range a.phoneticKeys(index, d.Term()) {
+			if err := conn.Send("ZADD", k, score, docKey); err != nil {
+				return err
+			}
+		}
+	}
+
 	if err := conn.Send(
-		"HSET", a.prefix+":$"+index, docKey, string(b)); err != nil {
+		"HSET", a.documentsKey(index), docKey, string(b)); err != nil {
+
+		return err
+	}
+
+	// the target layout only adds stored documents, so it is written after
+	// the document is stored
+	if l.migrating() {
+		if err := a.sendSecondaryIndex(conn, l.target, index, docKey,
+			d.Term(), score, false); err != nil {
+
+			return err
+		}
+	}
 
+	if err := conn.Send("HSET", a.termsKey(index), docKey, d.Term()); err != nil {
 		return err
 	}
 
-	if _, err := conn.Do("EXEC"); err != nil {
+	if err := conn.Send("HSET", a.idsKey(index), d.ID(), docKey); err != nil {
 		return err
 	}
 
+	if _, ok := a.halfLives[index]; ok {
+		if err := conn.Send("HSET", a.activityKey(index), docKey,
+			time.Now().Unix()); err != nil {
+
+			return err
+		}
+	}
+
+	if expiresAt.IsZero() {
+		if err := conn.Send("ZREM", a.expiryKey(index), docKey); err != nil {
+			return err
+		}
+	} else {
+		if err := conn.Send("ZADD", a.expiryKey(index), expiresAt.Unix(),
+			docKey); err != nil {
+
+			return err
+		}
+	}
+
 	return nil
 }
 
 // RemoveDocument removes a document from the autocomplete search index
 func (a *Autocomplete) RemoveDocument(index string, d Document) error {
-	conn := a.pool.Get()
+	l, err := a.layout(index)
+	if err != nil {
+		return err
+	}
+
+	return a.removeKey(index, l.docKey(d), d.Term())
+}
+
+// removeKey removes the document with the given key and term from the
+// autocomplete search index
+func (a *Autocomplete) removeKey(index, docKey, term string) error {
+	conn := a.conn()
 	defer conn.Close()
 
-	docKey := key(d)
+	l, err := a.layout(index)
+	if err != nil {
+		return err
+	}
+
+	member, err := a.termsMember(conn, l.indexType, index, docKey)
+	if err != nil {
+		return err
+	}
+
+	if l.indexType == PrefixesIndexing {
+		if err := a.checkIndexed(conn, index, docKey); err != nil {
+			return err
+		}
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
 
-	switch a.indexType {
+	if err := a.sendRemove(conn, l, index, docKey, term, member); err != nil {
+		return err
+	}
+
+	if err := replyError(conn.Do("EXEC")); err != nil {
+		return err
+	}
+
+	return a.invalidate(conn, index)
+}
+
+// termsMember returns the member of a document in a terms indexing ZSET, or
+// an empty string in prefixes indexing
+func (a *Autocomplete) termsMember(conn *redisConn, indexType int, index,
+	docKey string) (string, error) {
+
+	switch indexType {
 	case PrefixesIndexing:
-		for _, p := range prefixes(d) {
-			if err := conn.Send("MULTI"); err != nil {
-				return err
-			}
+		return "", nil
+
+	case TermsIndexing:
+		member, err := replyString(a.runScript(conn, "removeDocument",
+			a.termsIndexKey(index), a.memberSuffix(docKey)))
+		if errors.Is(err, ErrKeyNotFound) {
+			return "", a.notFound(conn, index, docKey, err)
+		}
 
+		return member, err
+
+	default:
+		return "", ErrInvalidIndexType
+	}
+}
+
+// sendRemove queues the commands that remove a document with the given key,
+// term and terms indexing member from a layout on a connection
+func (a *Autocomplete) sendRemove(conn *redisConn, l layout, index, docKey,
+	term, member string) error {
+
+	switch l.indexType {
+	case PrefixesIndexing:
+		for _, p := range a.termPrefixes(term) {
 			if err := conn.Send(
-				"ZREM", a.prefix+":"+index+":"+p, docKey); err != nil {
+				"ZREM", a.prefixKey(index, p), docKey); err != nil {
 
 				return err
 			}
 		}
 
 	case TermsIndexing:
-		script, ok := a.scripts["removeDocument"]
-		if !ok {
-			return fmt.Errorf("initialization error")
-		}
+		if err := conn.Send(
+			"ZREM", a.termsIndexKey(index), member); err != nil {
 
-		zmember, err := redis.String(script.Do(conn, a.prefix+":$$"+index, docKey))
-		if err != nil {
 			return err
 		}
 
-		if err := conn.Send("MULTI"); err != nil {
-			return err
-		}
+	default:
+		return ErrInvalidIndexType
+	}
 
-		if err := conn.Send(
-			"ZREM", a.prefix+":$$"+index, zmember); err != nil {
+	if l.migrating() {
+		if err := a.sendSecondaryRemove(conn, l.target, index, docKey,
+			term); err != nil {
 
 			return err
 		}
+	}
 
-	default:
-		return ErrInvalidIndexType
+	if a.phonetic {
+		for _, k := range a.phoneticKeys(index, term) {
+			if err := conn.Send("ZREM", k, docKey); err != nil {
+				return err
+			}
+		}
 	}
 
 	if err := conn.Send(
-		"HDEL", a.prefix+":"+"$"+index, docKey); err != nil {
+		"HDEL", a.documentsKey(index), docKey); err != nil {
+		return err
+	}
+
+	if err := conn.Send("HDEL", a.termsKey(index), docKey); err != nil {
+		return err
+	}
+
+	if err := conn.Send("HDEL", a.activityKey(index), docKey); err != nil {
 		return err
 	}
 
-	if _, err := conn.Do("EXEC"); err != nil {
+	if err := conn.Send("ZREM", a.expiryKey(index), docKey); err != nil {
 		return err
 	}
 
+	if id, ok := keyID(l.docKeys, docKey, term); ok {
+		script, err := a.script("hdelIfEquals")
+		if err != nil {
+			return err
+		}
+
+		if err := script.Send(conn, a.idsKey(index), id, docKey); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -142,18 +309,18 @@ func (a *Autocomplete) RemoveDocument(index string, d Document) error {
 //
 // if one of those is changed, the document should be removed and re-indexed
 func (a *Autocomplete) UpdateDocument(index string, d Document) error {
-	conn := a.pool.Get()
-	defer conn.Close()
-
-	docKey := key(d)
-
-	exists, err := redis.Bool(conn.Do("HEXISTS", a.prefix+":$"+index, docKey))
+	l, err := a.layout(index)
 	if err != nil {
 		return err
 	}
 
-	if !exists {
-		return fmt.Errorf("%s does not contain %s", a.prefix+":$"+index, docKey)
+	conn := a.conn()
+	defer conn.Close()
+
+	docKey := l.docKey(d)
+
+	if err := a.checkIndexed(conn, index, docKey); err != nil {
+		return err
 	}
 
 	b, err := json.Marshal(d)
@@ -162,52 +329,59 @@ func (a *Autocomplete) UpdateDocument(index string, d Document) error {
 	}
 
 	if _, err := conn.Do(
-		"HSET", a.prefix+":$"+index, docKey, string(b)); err != nil {
+		"HSET", a.documentsKey(index), docKey, string(b)); err != nil {
 
 		return err
 	}
 
-	return nil
+	return a.invalidate(conn, index)
 }
 
 // UpdateScore updates the score of a document
 func (a *Autocomplete) UpdateScore(index string, d Document, score uint64) error {
-	conn := a.pool.Get()
+	conn := a.conn()
 	defer conn.Close()
 
-	docKey := key(d)
+	l, err := a.layout(index)
+	if err != nil {
+		return err
+	}
+
+	docKey := l.docKey(d)
 
-	switch a.indexType {
+	switch l.indexType {
 	case PrefixesIndexing:
+		if err := a.checkIndexed(conn, index, docKey); err != nil {
+			return err
+		}
+
 		if err := conn.Send("MULTI"); err != nil {
 			return err
 		}
 
-		for _, p := range prefixes(d) {
-			if err := conn.Send("ZADD", a.prefix+":"+index+":"+p,
+		for _, p := range a.prefixes(d) {
+			if err := conn.Send("ZADD", a.prefixKey(index, p),
 				score, docKey); err != nil {
 
 				return err
 			}
 		}
 
-		if _, err := conn.Do("EXEC"); err != nil {
+		if err := replyError(conn.Do("EXEC")); err != nil {
 			return err
 		}
 
 	case TermsIndexing:
-		script, ok := a.scripts["updateScore"]
-		if !ok {
-			return fmt.Errorf("initialization error")
-		}
-
-		scoreStr, err := scoreString(score)
+		val, err := a.encodeMember(d.Term(), score, docKey)
 		if err != nil {
 			return err
 		}
 
-		val := strings.ToLower(d.Term()) + "::" + scoreStr + "::" + docKey
-		if _, err := script.Do(conn, a.prefix+":$$"+index, docKey, val); err != nil {
+		if _, err := a.runScript(conn, "updateScore", a.termsIndexKey(index),
+			a.memberSuffix(docKey), val); errors.Is(err, ErrKeyNotFound) {
+
+			return a.notFound(conn, index, docKey, err)
+		} else if err != nil {
 			return err
 		}
 
@@ -215,7 +389,41 @@ func (a *Autocomplete) UpdateScore(index string, d Document, score uint64) error
 		return ErrInvalidIndexType
 	}
 
-	return nil
+	if a.phonetic || l.migrating() {
+		if err := conn.Send("MULTI"); err != nil {
+			return err
+		}
+
+		if l.migrating() {
+			if err := a.sendSecondaryIndex(conn, l.target, index, docKey,
+				d.Term(), score, false); err != nil {
+
+				return err
+			}
+		}
+
+		if a.phonetic {
+			for _, k := range a.phoneticKeys(index, d.Term()) {
+				if err := conn.Send("ZADD", k, score, docKey); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := replyError(conn.Do("EXEC")); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := a.halfLives[index]; ok {
+		if _, err := conn.Do("HSET", a.activityKey(index), docKey,
+			time.Now().Unix()); err != nil {
+
+			return err
+		}
+	}
+
+	return a.invalidate(conn, index)
 }
 
 func scoreString(score uint64) (string, error) {
@@ -227,3 +435,17 @@ func scoreString(score uint64) (string, error) {
 
 	return base64.URLEncoding.EncodeToString(buf.Bytes()), nil
 }
+
+func parseScoreString(s string) (uint64, error) {
+	b, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var score uint64
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &score); err != nil {
+		return 0, err
+	}
+
+	return score, nil
+}
@@ -1,37 +1,23 @@
-package autocomplete
+package autocomplete_test
 
 import (
 	"log"
-	"time"
 
-	"github.com/garyburd/redigo/redis"
+	"github.com/augurysys/autocomplete"
+	"github.com/augurysys/autocomplete/garyburd"
 )
 
 func ExampleAutocomplete_Index() {
-	pool := &redis.Pool{
-		MaxIdle:     3,
-		MaxActive:   20,
-		IdleTimeout: 240 * time.Second,
-		Wait:        true,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", "localhost:6379")
-			if err != nil {
-				return nil, err
-			}
-
-			return c, err
-		},
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("PING")
-			return err
-		},
-	}
-
+	pool := newPool()
 	defer pool.Close()
 
-	a := New(pool, "ac", TermsIndexing)
+	a, err := autocomplete.New(garyburd.NewSource(pool), "ac",
+		autocomplete.TermsIndexing)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	docs := []doc{
+	cars := []car{
 		{
 			DocID: "1",
 			Name:  "Mercedes S500",
@@ -46,8 +32,8 @@ func ExampleAutocomplete_Index() {
 		},
 	}
 
-	for _, d := range docs {
-		if err := a.Index("cars", d, 0); err != nil {
+	for _, c := range cars {
+		if err := a.Index("cars", c, 0); err != nil {
 			log.Fatal(err)
 		}
 	}
@@ -3,10 +3,18 @@
 package autocomplete
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -18,18 +26,133 @@ var autocomplete *Autocomplete
 
 var redisURL string
 var redisPassword string
+var redisCluster string
+var redisServer string
 var prefix string
 
 func init() {
 	url := flag.String("redis_url", "localhost:6379", "Redis URL")
 	password := flag.String("redis_password", "", "Redis password")
 	prx := flag.String("prefix", "ac", "Prefix of Redis keys")
+	cluster := flag.String("redis_cluster", "",
+		"Comma separated addresses of all Redis Cluster masters")
+	server := flag.String("redis_server", "",
+		"Path of a redis-server binary for spawning Sentinel deployments")
 
 	flag.Parse()
 
 	prefix = *prx
 	redisURL = *url
 	redisPassword = *password
+	redisCluster = *cluster
+	redisServer = *server
+}
+
+// testSource is a connection source of a redigo pool for the integration
+// tests, the adapter packages import this package so they cannot be used here
+type testSource struct {
+	*redis.Pool
+}
+
+func (s testSource) Get() Conn {
+	return testConn{s.Pool.Get()}
+}
+
+func (s testSource) Subscribe(channel string) (Subscription, error) {
+	c, err := s.Pool.Dial()
+	if err != nil {
+		return nil, err
+	}
+
+	psc := redis.PubSubConn{Conn: c}
+	if err := psc.Subscribe(channel); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	if err, ok := psc.Receive().(error); ok {
+		c.Close()
+		return nil, err
+	}
+
+	return testSubscription{psc}, nil
+}
+
+type testSubscription struct {
+	psc redis.PubSubConn
+}
+
+func (s testSubscription) Receive() ([]byte, error) {
+	for {
+		switch m := s.psc.Receive().(type) {
+		case redis.Message:
+			return m.Data, nil
+
+		case error:
+			return nil, m
+		}
+	}
+}
+
+func (s testSubscription) Close() error {
+	return s.psc.Close()
+}
+
+type testConn struct {
+	redis.Conn
+}
+
+func (c testConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return testReply(c.Conn.Do(cmd, args...))
+}
+
+func (c testConn) Pipeline(cmds []Command) ([]interface{}, error) {
+	for _, cmd := range cmds {
+		if err := c.Conn.Send(cmd.Name, cmd.Args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		r, err := testReply(c.Conn.Receive())
+		if e, ok := err.(ReplyError); ok {
+			r = e
+		} else if err != nil {
+			return nil, err
+		}
+
+		replies[i] = r
+	}
+
+	return replies, nil
+}
+
+// testReply converts the redigo error replies of a reply
+func testReply(v interface{}, err error) (interface{}, error) {
+	if e, ok := err.(redis.Error); ok {
+		err = ReplyError(e)
+	}
+
+	return testValue(v), err
+}
+
+func testValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case redis.Error:
+		return ReplyError(v)
+
+	case []interface{}:
+		for i, e := range v {
+			v[i] = testValue(e)
+		}
+	}
+
+	return v
 }
 
 type TestStruct interface {
@@ -74,7 +197,29 @@ func setUp(t TestStruct, indexType int) {
 
 	flushall(t)
 
-	autocomplete = New(pool, prefix, indexType)
+	autocomplete = newAutocomplete(t, testSource{pool}, indexType)
+}
+
+func newAutocomplete(t TestStruct, source ConnSource, indexType int,
+	opts ...Option) *Autocomplete {
+
+	a, err := New(source, prefix, indexType, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+func newSharded(t TestStruct, pools []ConnSource, indexType int,
+	opts ...Option) *Sharded {
+
+	s, err := NewSharded(pools, prefix, indexType, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
 }
 
 func tearDown(t TestStruct) {
@@ -566,3 +711,2635 @@ func BenchmarkSearchTermsIndexingScoreSort(b *testing.B) {
 
 	b.StopTimer()
 }
+
+func TestPhoneticSearch(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+		autocomplete.SetPhonetic(true)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Catherine Smith",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Katherine Smyth",
+		}
+
+		if err := autocomplete.Index("test_index", d1, 100); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.Index("test_index", d2, 200); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := autocomplete.SearchWithOptions("test_index", "katherine",
+			SearchOptions{Sort: SortRevScore, Phonetic: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+
+		// literal matches are ranked above phonetic matches
+		var d doc
+		if err := json.Unmarshal(results[0], &d); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(d, d2) {
+			t.Fail()
+		}
+
+		if err := autocomplete.RemoveDocument("test_index", d1); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err = autocomplete.SearchWithOptions("test_index", "catherine",
+			SearchOptions{Sort: SortRevScore, Phonetic: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestPhraseSearch(t *testing.T) {
+	setUp(t, PrefixesIndexing)
+	defer tearDown(t)
+
+	d1 := doc{
+		DocID: "1",
+		Name:  "York New Station",
+	}
+
+	d2 := doc{
+		DocID: "2",
+		Name:  "New York",
+	}
+
+	if err := autocomplete.Index("test_index", d1, 200); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := autocomplete.Index("test_index", d2, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := autocomplete.SearchWithOptions("test_index", "new yo",
+		SearchOptions{Sort: SortRevScore, Phrase: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(results))
+	}
+
+	var d doc
+	if err := json.Unmarshal(results[0], &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(d, d2) {
+		t.Fail()
+	}
+
+	// in order matches are boosted above higher scored out of order matches
+	results, err = autocomplete.SearchWithOptions("test_index", "new yo",
+		SearchOptions{Sort: SortRevScore, InOrderBoost: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	if err := json.Unmarshal(results[0], &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(d, d2) {
+		t.Fail()
+	}
+}
+
+func TestRelevanceSearch(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Fordham University",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Ford",
+		}
+
+		if err := autocomplete.Index("test_index", d1, 1000); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.Index("test_index", d2, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := autocomplete.Search("test_index", "ford", SortRelevance)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+
+		var d doc
+		if err := json.Unmarshal(results[0], &d); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(d, d2) {
+			t.Fail()
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestDecayedScoreSearch(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+		autocomplete.SetDecay("test_index", 24*time.Hour)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Test old",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Test new",
+		}
+
+		if err := autocomplete.Index("test_index", d1, 1000); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.Index("test_index", d2, 100); err != nil {
+			t.Fatal(err)
+		}
+
+		// ten half lives ago decays 1000 below 100
+		if err := autocomplete.Touch("test_index", d1,
+			time.Now().Add(-240*time.Hour)); err != nil {
+
+			t.Fatal(err)
+		}
+
+		results, err := autocomplete.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+
+		var d doc
+		if err := json.Unmarshal(results[0], &d); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(d, d2) {
+			t.Fail()
+		}
+
+		// documents with no recorded activity decay from the time decay was
+		// first used for the index
+		d3 := doc{
+			DocID: "3",
+			Name:  "Test inactive",
+		}
+
+		if err := autocomplete.Index("test_index", d3, 500); err != nil {
+			t.Fatal(err)
+		}
+
+		conn := pool.Get()
+		if _, err := conn.Do("HDEL", autocomplete.activityKey("test_index"),
+			key(d3)); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if _, err := conn.Do("SET", autocomplete.decayStartKey("test_index"),
+			time.Now().Add(-240*time.Hour).Unix()); err != nil {
+
+			t.Fatal(err)
+		}
+
+		conn.Close()
+
+		results, err = autocomplete.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		docs := []doc{}
+		for _, r := range results {
+			if err := json.Unmarshal(r, &d); err != nil {
+				t.Fatal(err)
+			}
+
+			docs = append(docs, d)
+		}
+
+		if expected := []doc{d2, d1, d3}; !reflect.DeepEqual(docs, expected) {
+			t.Fatalf("expected %+v, got %+v", expected, docs)
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestRecordSelection(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+		autocomplete.SetClickTracking(true)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Pump one",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Pump two",
+		}
+
+		if err := autocomplete.Index("test_index", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.Index("test_index", d2, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := autocomplete.RecordSelection("test_index", "pu",
+				d1); err != nil {
+
+				t.Fatal(err)
+			}
+		}
+
+		// the selections raised d1's score from 1 to 3
+		results, err := autocomplete.Search("test_index", "pump", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+
+		var d doc
+		if err := json.Unmarshal(results[0], &d); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(d, d1) {
+			t.Fail()
+		}
+
+		// d2 is boosted for the query it was selected for
+		if err := autocomplete.RecordSelection("test_index", "pump t",
+			d2); err != nil {
+
+			t.Fatal(err)
+		}
+
+		results, err = autocomplete.SearchWithOptions("test_index", "pump t",
+			SearchOptions{Sort: SortScore, ClickBoost: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) == 0 {
+			t.Fatal("expected results")
+		}
+
+		if err := json.Unmarshal(results[0], &d); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(d, d2) {
+			t.Fail()
+		}
+
+		if err := autocomplete.RecordSelection("test_index", "pu",
+			doc{DocID: "3", Name: "Missing"}); err == nil {
+
+			t.Fail()
+		}
+
+		// failed commands of the selection's transaction are returned
+		conn := pool.Get()
+		if _, err := conn.Do("SET", autocomplete.clicksKey("test_index",
+			"broken"), "x"); err != nil {
+
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		if err := autocomplete.RecordSelection("test_index", "broken",
+			d1); err == nil {
+
+			t.Fatal("expected the failed click count to be returned")
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestPersonalizedSearch(t *testing.T) {
+	setUp(t, PrefixesIndexing)
+	defer tearDown(t)
+
+	d1 := doc{
+		DocID: "1",
+		Name:  "Pump one",
+	}
+
+	d2 := doc{
+		DocID: "2",
+		Name:  "Pump two",
+	}
+
+	if err := autocomplete.Index("test_index", d1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := autocomplete.Index("test_index", d2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := autocomplete.RecordUserSelection("test_index", "user1",
+		d1); err != nil {
+
+		t.Fatal(err)
+	}
+
+	for _, sort := range []int{SortRevScore, SortRelevance} {
+		results, err := autocomplete.SearchWithOptions("test_index", "pump",
+			SearchOptions{Sort: sort, User: "user1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+
+		var d doc
+		if err := json.Unmarshal(results[0], &d); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(d, d1) {
+			t.Fail()
+		}
+	}
+
+	recent, err := autocomplete.RecentSelections("test_index", "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recent) != 1 {
+		t.Fatalf("expected 1 recent selection, got %d", len(recent))
+	}
+
+	if err := autocomplete.ClearRecentSelections("test_index",
+		"user1"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	recent, err = autocomplete.RecentSelections("test_index", "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recent) != 0 {
+		t.Fail()
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+	setUp(t, PrefixesIndexing)
+	defer tearDown(t)
+
+	autocomplete.SetQueryLog(24 * time.Hour)
+
+	queries := []string{"pump", "pump", "Pump ", "pu", "motor"}
+	for _, q := range queries {
+		if _, err := autocomplete.Search("test_index", q,
+			SortLexicographical); err != nil {
+
+			t.Fatal(err)
+		}
+	}
+
+	top, err := autocomplete.TopQueries("test_index", time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(top, []string{"pump", "motor"}) &&
+		!reflect.DeepEqual(top, []string{"pump", "pu"}) {
+
+		t.Errorf("unexpected top queries %v", top)
+	}
+
+	completions, err := autocomplete.SearchQueries("test_index", "p", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(completions, []string{"pump", "pu"}) {
+		t.Errorf("unexpected query completions %v", completions)
+	}
+
+	// the least executed queries are dropped
+	autocomplete.SetQueryLogSize(2)
+	if err := autocomplete.RecordQuery("test_index", "pipe"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{"p", "m"} {
+		completions, err = autocomplete.SearchQueries("test_index", p, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{}
+		if p == "p" {
+			expected = []string{"pump", "pu"}
+		}
+
+		if !reflect.DeepEqual(completions, expected) {
+			t.Errorf("unexpected query completions of %s %v", p, completions)
+		}
+	}
+
+	// the queries expire with the retention
+	conn := pool.Get()
+	ttl, err := redis.Int64(conn.Do("PTTL", autocomplete.queryCountsKey(
+		"test_index")))
+	conn.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl <= 0 || ttl > int64(24*time.Hour/time.Millisecond) {
+		t.Errorf("unexpected TTL %d", ttl)
+	}
+}
+
+func TestSpellSuggest(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		d := doc{
+			DocID: "1",
+			Name:  "Water pump",
+		}
+
+		if err := autocomplete.Index("test_index", d, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		suggestion, err := autocomplete.SpellSuggest("test_index", "watr pu")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if suggestion != "water pu" {
+			t.Errorf("unexpected suggestion %q", suggestion)
+		}
+
+		suggestion, err = autocomplete.SpellSuggest("test_index", "water")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if suggestion != "" {
+			t.Errorf("unexpected suggestion %q", suggestion)
+		}
+
+		results, err := autocomplete.SearchWithOptions("test_index", "watr pu",
+			SearchOptions{Sort: SortScore, SpellRetry: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Alert expired",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Alert active",
+		}
+
+		if err := autocomplete.IndexWithExpiry("test_index", d1, 1,
+			time.Now().Add(-time.Minute)); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.IndexWithExpiry("test_index", d2, 1,
+			time.Now().Add(time.Hour)); err != nil {
+
+			t.Fatal(err)
+		}
+
+		// expired documents are filtered before they are swept
+		results, err := autocomplete.Search("test_index", "alert", SortScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		// the entry of a document that was already removed is swept first
+		conn := pool.Get()
+		if _, err := conn.Do("ZADD", autocomplete.expiryKey("test_index"), 0,
+			"ghost_1"); err != nil {
+
+			t.Fatal(err)
+		}
+
+		// an expired document that is stored but no longer indexed is dropped
+		d3 := doc{
+			DocID: "3",
+			Name:  "Alert broken",
+		}
+
+		if err := autocomplete.IndexWithExpiry("test_index", d3, 1,
+			time.Now().Add(-time.Minute)); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if indexType == TermsIndexing {
+			members, err := redis.Strings(conn.Do("ZRANGE",
+				autocomplete.termsIndexKey("test_index"), 0, -1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, m := range members {
+				if !strings.HasSuffix(m, autocomplete.memberSuffix(key(d3))) {
+					continue
+				}
+
+				if _, err := conn.Do("ZREM",
+					autocomplete.termsIndexKey("test_index"), m); err != nil {
+
+					t.Fatal(err)
+				}
+			}
+		}
+		conn.Close()
+
+		swept := make(chan error, 1)
+		var removed int
+		go func() {
+			var err error
+			removed, err = autocomplete.SweepExpired("test_index")
+			swept <- err
+		}()
+
+		select {
+		case err := <-swept:
+			if err != nil {
+				t.Fatal(err)
+			}
+
+		case <-time.After(10 * time.Second):
+			t.Fatal("sweeping did not finish")
+		}
+
+		expected := 2
+		if indexType == TermsIndexing {
+			expected = 1
+		}
+
+		if removed != expected {
+			t.Errorf("expected %d removed documents, got %d", expected,
+				removed)
+		}
+
+		conn = pool.Get()
+		exists, err := redis.Bool(conn.Do("HEXISTS", prefix+":$test_index",
+			key(d1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exists {
+			t.Error("expired document was not removed")
+		}
+
+		exists, err = redis.Bool(conn.Do("HEXISTS", prefix+":$test_index",
+			key(d3)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exists {
+			t.Error("expired document that is not indexed was not removed")
+		}
+
+		n, err := redis.Int(conn.Do("ZCARD",
+			autocomplete.expiryKey("test_index")))
+		conn.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 1 {
+			t.Errorf("expected only the active entry, got %d entries", n)
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Old name",
+		}
+
+		d2 := doc{
+			DocID: "1",
+			Name:  "New name",
+		}
+
+		if err := autocomplete.Upsert("test_index", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.Upsert("test_index", d2, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		// the same term can be upserted again with a new score
+		if err := autocomplete.Upsert("test_index", d2, 3); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := autocomplete.Search("test_index", "old", SortScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 0 {
+			t.Fatalf("expected no results, got %d", len(results))
+		}
+
+		results, err = autocomplete.Search("test_index", "new", SortScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		var d doc
+		if err := json.Unmarshal(results[0], &d); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(d, d2) {
+			t.Fail()
+		}
+
+		// a replaced document keeps its expiry
+		d3 := doc{
+			DocID: "3",
+			Name:  "Expiring name",
+		}
+
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		if err := autocomplete.IndexWithExpiry("test_index", d3, 1,
+			expiresAt); err != nil {
+
+			t.Fatal(err)
+		}
+
+		d3.Name = "Renamed"
+		if err := autocomplete.Upsert("test_index", d3, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		conn := pool.Get()
+		expiry, err := redis.Int64(conn.Do("ZSCORE",
+			autocomplete.expiryKey("test_index"), key(d3)))
+		conn.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expiry != expiresAt.Unix() {
+			t.Fatalf("expected expiry %d, got %d", expiresAt.Unix(), expiry)
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestRemoveByID(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		d := doc{
+			DocID: "1",
+			Name:  "Test term",
+		}
+
+		if err := autocomplete.Index("test_index", d, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.RemoveByID("test_index", "1"); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := autocomplete.Search("test_index", "test", SortScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 0 {
+			t.Fatalf("expected no results, got %d", len(results))
+		}
+
+		if err := autocomplete.RemoveByID("test_index", "1"); err == nil {
+			t.Error("expected an error removing a missing document")
+		}
+
+		conn := pool.Get()
+		n, err := redis.Int(conn.Do("DBSIZE"))
+		conn.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 0 {
+			t.Errorf("expected no keys left, got %d", n)
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestVerifyAndRepair(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Test one",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Test two",
+		}
+
+		d3 := doc{
+			DocID: "3",
+			Name:  "Test three",
+		}
+
+		for _, d := range []doc{d1, d2, d3} {
+			if err := autocomplete.Index("test_index", d, 10); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		conn := pool.Get()
+
+		// d2 is missing from the documents hash
+		if _, err := conn.Do("HDEL", prefix+":$test_index", key(d2)); err != nil {
+			t.Fatal(err)
+		}
+
+		// d3 is missing from a prefix with a mismatched score in another
+		if indexType == PrefixesIndexing {
+			if _, err := conn.Do("ZREM", prefix+":test_index:th",
+				key(d3)); err != nil {
+
+				t.Fatal(err)
+			}
+
+			if _, err := conn.Do("ZADD", prefix+":test_index:thr", 20,
+				key(d3)); err != nil {
+
+				t.Fatal(err)
+			}
+		}
+
+		conn.Close()
+
+		report, err := autocomplete.Verify("test_index")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Documents != 2 || report.OrphanMembers == 0 ||
+			report.OrphanEntries != 2 {
+
+			t.Errorf("unexpected report %+v", report)
+		}
+
+		if indexType == PrefixesIndexing && (report.MissingPrefixes != 1 ||
+			report.MismatchedScores != 1) {
+
+			t.Errorf("unexpected report %+v", report)
+		}
+
+		if _, err := autocomplete.Repair("test_index"); err != nil {
+			t.Fatal(err)
+		}
+
+		report, err = autocomplete.Verify("test_index")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(report, VerifyReport{Documents: 2}) {
+			t.Errorf("unexpected report after repair %+v", report)
+		}
+
+		results, err := autocomplete.Search("test_index", "test th", SortScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		// the legacy keys of test_index:eu extend the keys of test_index,
+		// they are not repaired as its orphans
+		if err := autocomplete.Index("test_index:eu", d2, 10); err != nil {
+			t.Fatal(err)
+		}
+
+		report, err = autocomplete.Repair("test_index")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(report, VerifyReport{Documents: 2}) {
+			t.Errorf("unexpected repair report %+v", report)
+		}
+
+		results, err = autocomplete.Search("test_index:eu", "test", SortScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result of test_index:eu, got %d",
+				len(results))
+		}
+
+		// entries of documents stored after the scan are not removed
+		conn = pool.Get()
+		n, err := autocomplete.removeOrphans(newRedisConn(testConn{conn}),
+			"test_index", "HDEL", autocomplete.idsKey("test_index"),
+			[]string{"1", key(d1)}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exists, err := redis.Bool(conn.Do("HEXISTS",
+			autocomplete.idsKey("test_index"), "1"))
+		conn.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 0 || !exists {
+			t.Errorf("expected the entry of a stored document to be kept")
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		toType := TermsIndexing
+		if indexType == TermsIndexing {
+			toType = PrefixesIndexing
+		}
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Test one",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Test two",
+		}
+
+		d3 := doc{
+			DocID: "3",
+			Name:  "Test three",
+		}
+
+		if err := autocomplete.Index("test_index", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.Index("test_index", d2, 5); err != nil {
+			t.Fatal(err)
+		}
+
+		// the legacy keys of test_index:eu extend the keys of test_index
+		if err := autocomplete.Index("test_index:eu", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan error)
+		go func() {
+			done <- autocomplete.Migrate("test_index", toType)
+		}()
+
+		// writes during the migration are applied to both layouts
+		time.Sleep(autocomplete.layoutTTL)
+
+		if err := autocomplete.Index("test_index", d3, 3); err != nil {
+			t.Fatal(err)
+		}
+
+		// the new document is written to the target layout before the
+		// documents are copied
+		conn := pool.Get()
+		target := autocomplete.termsIndexKey("test_index")
+		if toType == PrefixesIndexing {
+			target = autocomplete.prefixKey("test_index", "three")
+		}
+
+		n, err := redis.Int(conn.Do("ZCARD", target))
+		conn.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 1 {
+			t.Fatalf("expected d3 in the target layout, got %d members", n)
+		}
+
+		if err := autocomplete.RecordSelection("test_index", "test",
+			d1); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := autocomplete.Search("test_index", "test tw",
+			SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		results, err = autocomplete.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []doc{d2, d3, d1}
+		if len(results) != len(expected) {
+			t.Fatalf("expected %d results, got %d", len(expected),
+				len(results))
+		}
+
+		for i, r := range results {
+			var d doc
+			if err := json.Unmarshal(r, &d); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(d, expected[i]) {
+				t.Errorf("expected %+v at %d, got %+v", expected[i], i, d)
+			}
+		}
+
+		report, err := autocomplete.Verify("test_index")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(report, VerifyReport{Documents: 3}) {
+			t.Errorf("unexpected report %+v", report)
+		}
+
+		// the previous layout is deleted
+		conn = pool.Get()
+		old := prefix + ":$$test_index"
+		if indexType == PrefixesIndexing {
+			old = prefix + ":test_index:t"
+		}
+
+		exists, err := redis.Bool(conn.Do("EXISTS", old))
+		conn.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exists {
+			t.Errorf("expected %s to be deleted", old)
+		}
+
+		results, err = autocomplete.Search("test_index:eu", "test",
+			SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result of test_index:eu, got %d",
+				len(results))
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestEscapedKeySchema(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		if err := autocomplete.SetKeySchema(KeySchemaEscaped); err != nil {
+			t.Fatal(err)
+		}
+
+		d1 := doc{
+			DocID: "a:1",
+			Name:  "Test::term",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Test other",
+		}
+
+		// indexes whose legacy keys collide
+		if err := autocomplete.Index("test_index", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.Index("$test_index", d2, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := autocomplete.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		var d doc
+		if err := json.Unmarshal(results[0], &d); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(d, d1) {
+			t.Errorf("expected %+v, got %+v", d1, d)
+		}
+
+		if err := autocomplete.RecordSelection("test_index", "test",
+			d1); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.RemoveDocument("test_index", d1); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err = autocomplete.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 0 {
+			t.Fatalf("expected no results, got %d", len(results))
+		}
+
+		// documents whose legacy keys collide
+		d3 := doc{
+			DocID: "c",
+			Name:  "Test b",
+		}
+
+		d4 := doc{
+			DocID: "b_c",
+			Name:  "Test",
+		}
+
+		for _, d := range []doc{d3, d4} {
+			if err := autocomplete.Index("test_index", d, 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		results, err = autocomplete.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+
+		version, err := autocomplete.KeySchemaVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if version != KeySchemaEscaped {
+			t.Errorf("expected stored version %d, got %d", KeySchemaEscaped,
+				version)
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestMigrateKeySchema(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Test one",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Test two",
+		}
+
+		if err := autocomplete.Index("test_index", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.Index("test_index", d2, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.MigrateKeySchema("test_index"); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := autocomplete.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []doc{d2, d1}
+		if len(results) != len(expected) {
+			t.Fatalf("expected %d results, got %d", len(expected),
+				len(results))
+		}
+
+		for i, r := range results {
+			var d doc
+			if err := json.Unmarshal(r, &d); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(d, expected[i]) {
+				t.Errorf("expected %+v at %d, got %+v", expected[i], i, d)
+			}
+		}
+
+		// migrated documents keep their legacy keys, so they are not indexed
+		// again under other keys
+		if err := autocomplete.RecordSelection("test_index", "test",
+			d1); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.RemoveDocument("test_index", d2); err != nil {
+			t.Fatal(err)
+		}
+
+		report, err := autocomplete.Verify("test_index")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(report, VerifyReport{Documents: 1}) {
+			t.Errorf("unexpected report %+v", report)
+		}
+
+		version, err := autocomplete.KeySchemaVersion()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if version != KeySchemaEscaped {
+			t.Errorf("expected stored version %d, got %d", KeySchemaEscaped,
+				version)
+		}
+
+		// no legacy keys are left
+		conn := pool.Get()
+		keys, err := redis.Strings(conn.Do("KEYS", prefix+":*"))
+		conn.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, k := range keys {
+			if k != prefix+":schema" &&
+				!strings.HasPrefix(k, prefix+":2:") {
+
+				t.Errorf("unexpected legacy key %s", k)
+			}
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	if redisCluster == "" {
+		t.Skip("no Redis Cluster masters given by -redis_cluster")
+	}
+
+	addrs := strings.Split(redisCluster, ",")
+
+	cluster, err := NewCluster(addrs, func(addr string) ConnSource {
+		return testSource{&redis.Pool{
+			MaxIdle:     3,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				c, err := redis.Dial("tcp", addr)
+				if err != nil {
+					return nil, err
+				}
+
+				if redisPassword != "" {
+					if _, err := c.Do("AUTH", redisPassword); err != nil {
+						c.Close()
+						return nil, err
+					}
+				}
+
+				return c, err
+			},
+		}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	flush := func() {
+		for _, addr := range addrs {
+			conn := cluster.source(addr).Get()
+			_, err := conn.Do("FLUSHALL")
+			conn.Close()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		flush()
+
+		a := newAutocomplete(t, cluster, indexType)
+		a.SetHashTags(true)
+
+		// scripts are loaded on every master
+		if err := a.LoadScripts(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		statuses, err := a.CheckScripts(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(statuses) != len(addrs) {
+			t.Fatalf("expected %d targets, got %+v", len(addrs), statuses)
+		}
+
+		for _, status := range statuses {
+			if !status.Healthy() {
+				t.Fatalf("expected the scripts loaded, got %+v", status)
+			}
+		}
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Test one",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Test two",
+		}
+
+		// indexes in different slots
+		for _, index := range []string{"test_index", "other_index", "cluster_index"} {
+			if err := a.Index(index, d1, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := a.Upsert(index, d2, 2); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := a.RecordSelection(index, "test", d1); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := a.UpdateScore(index, d2, 5); err != nil {
+				t.Fatal(err)
+			}
+
+			results, err := a.Search(index, "test tw", SortRevScore)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(results) != 1 {
+				t.Fatalf("expected 1 result, got %d", len(results))
+			}
+
+			results, err = a.Search(index, "test", SortRevScore)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := []doc{d2, d1}
+			if len(results) != len(expected) {
+				t.Fatalf("expected %d results, got %d", len(expected),
+					len(results))
+			}
+
+			for i, r := range results {
+				var d doc
+				if err := json.Unmarshal(r, &d); err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(d, expected[i]) {
+					t.Errorf("expected %+v at %d, got %+v", expected[i], i, d)
+				}
+			}
+
+			report, err := a.Verify(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(report, VerifyReport{Documents: 2}) {
+				t.Errorf("unexpected report %+v", report)
+			}
+
+			if err := a.RemoveDocument(index, d1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	flush()
+}
+
+func TestSharded(t *testing.T) {
+	// every shard is a database of the test server
+	shardPool := func(db int) ConnSource {
+		return testSource{&redis.Pool{
+			MaxIdle:     3,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				c, err := redis.Dial("tcp", redisURL)
+				if err != nil {
+					return nil, err
+				}
+
+				if redisPassword != "" {
+					if _, err := c.Do("AUTH", redisPassword); err != nil {
+						c.Close()
+						return nil, err
+					}
+				}
+
+				if _, err := c.Do("SELECT", db); err != nil {
+					c.Close()
+					return nil, err
+				}
+
+				return c, err
+			},
+		}}
+	}
+
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		pools := []ConnSource{shardPool(1), shardPool(2)}
+		s := newSharded(t, pools, indexType)
+		for _, a := range s.Shards() {
+			a.SetPhonetic(true)
+		}
+
+		expected := []doc{}
+		for i := 1; i <= 20; i++ {
+			d := doc{
+				DocID: strconv.Itoa(i),
+				Name:  "Test " + strconv.Itoa(i),
+			}
+
+			if err := s.Index("test_index", d, uint64(i)); err != nil {
+				t.Fatal(err)
+			}
+
+			expected = append([]doc{d}, expected...)
+		}
+
+		search := func(s *Sharded) {
+			results, err := s.Search("test_index", "test", SortRevScore)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			docs := []doc{}
+			for _, r := range results {
+				var d doc
+				if err := json.Unmarshal(r, &d); err != nil {
+					t.Fatal(err)
+				}
+
+				docs = append(docs, d)
+			}
+
+			if !reflect.DeepEqual(docs, expected) {
+				t.Fatalf("expected %+v, got %+v", expected, docs)
+			}
+		}
+
+		search(s)
+
+		// every document is stored only on its owner
+		owned := func(s *Sharded) {
+			for _, d := range expected {
+				for i, a := range s.Shards() {
+					exists, err := a.exists("test_index", key(d))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if exists != (i == s.shardOf(key(d))) {
+						t.Fatalf("%s is stored on shard %d: %v", key(d),
+							i, exists)
+					}
+				}
+			}
+		}
+
+		owned(s)
+
+		pools = append(pools, shardPool(3))
+		grown := newSharded(t, pools, indexType)
+		for _, a := range grown.Shards() {
+			a.SetPhonetic(true)
+		}
+
+		changed := 0
+		for _, d := range expected {
+			if grown.shardOf(key(d)) != s.shardOf(key(d)) {
+				changed++
+			}
+		}
+
+		if changed == 0 {
+			t.Fatal("expected documents to be owned by the added shard")
+		}
+
+		// documents on their previous owners are found during resharding
+		search(grown)
+
+		moved, err := grown.Reshard("test_index")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if moved != changed {
+			t.Fatalf("expected %d moved documents, got %d", changed, moved)
+		}
+
+		search(grown)
+		owned(grown)
+
+		if moved, err := grown.Reshard("test_index"); err != nil {
+			t.Fatal(err)
+		} else if moved != 0 {
+			t.Fatalf("expected 0 moved documents, got %d", moved)
+		}
+
+		// documents matched by phonetic codes or spelling corrections are
+		// merged by their scores
+		d21 := doc{
+			DocID: "21",
+			Name:  "Tost 21",
+		}
+
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		if err := grown.IndexWithExpiry("test_index", d21, 21,
+			expiresAt); err != nil {
+			t.Fatal(err)
+		}
+
+		check := func(query string, opts SearchOptions, expected []doc) {
+			results, err := grown.SearchWithOptions("test_index", query, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			docs := []doc{}
+			for _, r := range results {
+				var d doc
+				if err := json.Unmarshal(r, &d); err != nil {
+					t.Fatal(err)
+				}
+
+				docs = append(docs, d)
+			}
+
+			if !reflect.DeepEqual(docs, expected) {
+				t.Fatalf("%q: expected %+v, got %+v", query, expected, docs)
+			}
+		}
+
+		check("tost", SearchOptions{Sort: SortRevScore, Phonetic: true},
+			append([]doc{d21}, expected...))
+		check("tets", SearchOptions{Sort: SortRevScore, SpellRetry: true},
+			expected)
+
+		// a spelling correction is searched only when no shard matches
+		check("tost", SearchOptions{Sort: SortRevScore, SpellRetry: true},
+			[]doc{d21})
+
+		// results are merged by their clicks and the user's affinity before
+		// their scores
+		d1 := expected[len(expected)-1]
+		selected := append([]doc{d1}, expected[:len(expected)-1]...)
+
+		for _, a := range grown.Shards() {
+			a.SetClickTracking(true)
+		}
+
+		if err := grown.RecordSelection("test_index", "test", d1); err != nil {
+			t.Fatal(err)
+		}
+
+		check("test", SearchOptions{Sort: SortRevScore, ClickBoost: true},
+			selected)
+
+		if err := grown.RecordUserSelection("test_index", "user",
+			d1); err != nil {
+
+			t.Fatal(err)
+		}
+
+		check("test", SearchOptions{Sort: SortRevScore, User: "user"},
+			selected)
+
+		if err := grown.Touch("test_index", d1, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		// a document replaced with a new term is removed from its previous
+		// owner
+		d21.Name = "Tast 21"
+		if err := grown.Upsert("test_index", d21, 21); err != nil {
+			t.Fatal(err)
+		}
+
+		check("tost", SearchOptions{Sort: SortRevScore}, []doc{})
+		check("tast", SearchOptions{Sort: SortRevScore}, []doc{d21})
+
+		owner := grown.shard(d21)
+		if e, err := owner.expiryByID("test_index", "21"); err != nil {
+			t.Fatal(err)
+		} else if !e.Equal(expiresAt) {
+			t.Fatalf("expected expiry %s, got %s", expiresAt, e)
+		}
+
+		if err := grown.RemoveByID("test_index", "21"); err != nil {
+			t.Fatal(err)
+		}
+
+		check("tast", SearchOptions{Sort: SortRevScore}, []doc{})
+
+		if err := grown.RemoveByID("test_index",
+			"21"); !errors.Is(err, ErrDocumentNotFound) {
+
+			t.Fatalf("expected %v, got %v", ErrDocumentNotFound, err)
+		}
+
+		for _, sort := range []int{SortScore, SortRevScore, SortRelevance} {
+			if _, err := grown.SearchWithOptions("test_index", " ",
+				SearchOptions{Sort: sort}); err != nil {
+
+				t.Fatal(err)
+			}
+		}
+
+		for _, p := range pools {
+			p.(testSource).Close()
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestReadReplicas(t *testing.T) {
+	// the replica is an empty database of the test server, so it serves
+	// stale reads
+	replica := &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", redisURL)
+			if err != nil {
+				return nil, err
+			}
+
+			if redisPassword != "" {
+				if _, err := c.Do("AUTH", redisPassword); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+
+			if _, err := c.Do("SELECT", 1); err != nil {
+				c.Close()
+				return nil, err
+			}
+
+			return c, err
+		},
+	}
+
+	defer replica.Close()
+
+	unreachable := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", "127.0.0.1:1")
+		},
+	}
+
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Test one",
+		}
+
+		if err := autocomplete.Index("test_index", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		autocomplete.SetReadSource(NewReplicas(testSource{pool},
+			testSource{replica}))
+
+		results, err := autocomplete.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 0 {
+			t.Fatalf("expected 0 results from the replica, got %d",
+				len(results))
+		}
+
+		// intersections are stored on the primary, which serves the
+		// documents of the search
+		if indexType == PrefixesIndexing {
+			results, err = autocomplete.Search("test_index", "test one",
+				SortRevScore)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(results) != 1 {
+				t.Fatalf("expected 1 result from the primary, got %d",
+					len(results))
+			}
+		}
+
+		results, err = autocomplete.SearchWithOptions("test_index", "test",
+			SearchOptions{Sort: SortRevScore, ReadYourWrites: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result from the primary, got %d",
+				len(results))
+		}
+
+		// an unhealthy replica falls back to the primary
+		autocomplete.SetReadSource(NewReplicas(testSource{pool},
+			testSource{unreachable}))
+
+		results, err = autocomplete.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var d doc
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		if err := json.Unmarshal(results[0], &d); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(d, d1) {
+			t.Fatalf("expected %+v, got %+v", d1, d)
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestSentinel(t *testing.T) {
+	if redisServer == "" {
+		t.Skip("no redis-server binary given by -redis_server")
+	}
+
+	dir, err := ioutil.TempDir("", "autocomplete-sentinel")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// spawn starts a redis-server process with a config file
+	spawn := func(name, config string, args ...string) *exec.Cmd {
+		conf := filepath.Join(dir, name+".conf")
+		if err := ioutil.WriteFile(conf, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(redisServer, append([]string{conf}, args...)...)
+		cmd.Dir = dir
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		return cmd
+	}
+
+	newPool := func(addr string) ConnSource {
+		return testSource{&redis.Pool{
+			MaxIdle:     3,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", addr)
+			},
+		}}
+	}
+
+	// waitFor polls a condition for up to 30 seconds
+	waitFor := func(what string, cond func() bool) {
+		for i := 0; i < 300; i++ {
+			if cond() {
+				return
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		t.Fatalf("timed out waiting for %s", what)
+	}
+
+	master := spawn("master", "port 6480\ndbfilename master.rdb\n")
+	defer master.Process.Kill()
+
+	replica := spawn("replica", "port 6481\ndbfilename replica.rdb\n"+
+		"slaveof 127.0.0.1 6480\n")
+	defer replica.Process.Kill()
+
+	sentinel := spawn("sentinel", "port 26480\n"+
+		"sentinel monitor test_master 127.0.0.1 6480 1\n"+
+		"sentinel down-after-milliseconds test_master 1000\n"+
+		"sentinel failover-timeout test_master 5000\n", "--sentinel")
+	defer sentinel.Process.Kill()
+
+	waitFor("the replica to sync", func() bool {
+		conn, err := redis.Dial("tcp", "127.0.0.1:6481")
+		if err != nil {
+			return false
+		}
+
+		defer conn.Close()
+
+		info, err := redis.String(conn.Do("INFO", "replication"))
+		return err == nil && strings.Contains(info, "master_link_status:up")
+	})
+
+	var s *Sentinel
+	waitFor("the sentinel", func() bool {
+		s, err = NewSentinel([]string{"127.0.0.1:26480"}, "test_master",
+			newPool)
+
+		return err == nil
+	})
+
+	defer s.Close()
+
+	if s.Master() != "127.0.0.1:6480" {
+		t.Fatalf("expected master 127.0.0.1:6480, got %s", s.Master())
+	}
+
+	a := newAutocomplete(t, s, PrefixesIndexing)
+
+	d1 := doc{
+		DocID: "1",
+		Name:  "Test one",
+	}
+
+	d2 := doc{
+		DocID: "2",
+		Name:  "Test two",
+	}
+
+	if err := a.Index("test_index", d1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor("the document to replicate", func() bool {
+		conn, err := redis.Dial("tcp", "127.0.0.1:6481")
+		if err != nil {
+			return false
+		}
+
+		defer conn.Close()
+
+		exists, err := redis.Bool(conn.Do("HEXISTS", a.documentsKey("test_index"),
+			key(d1)))
+		return err == nil && exists
+	})
+
+	// the replica is promoted when the master dies
+	master.Process.Kill()
+	master.Wait()
+
+	waitFor("the failover", func() bool {
+		return s.Master() == "127.0.0.1:6481"
+	})
+
+	if err := a.Index("test_index", d2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := a.Search("test_index", "test", SortRevScore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs := []doc{}
+	for _, r := range results {
+		var d doc
+		if err := json.Unmarshal(r, &d); err != nil {
+			t.Fatal(err)
+		}
+
+		docs = append(docs, d)
+	}
+
+	expected := []doc{d2, d1}
+	if !reflect.DeepEqual(docs, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, docs)
+	}
+}
+
+func TestCache(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		// reader and writer are services of different processes
+		reader := newAutocomplete(t, testSource{pool}, indexType,
+			WithCache(100, time.Minute))
+
+		writer := newAutocomplete(t, testSource{pool}, indexType)
+		writer.SetCacheInvalidation(true)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Test one",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Test two",
+		}
+
+		d3 := doc{
+			DocID: "3",
+			Name:  "Test three",
+		}
+
+		if err := reader.Index("test_index", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		search := func(expected int) {
+			results, err := reader.Search("test_index", "Test", SortRevScore)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(results) != expected {
+				t.Fatalf("expected %d results, got %d", expected,
+					len(results))
+			}
+		}
+
+		search(1)
+
+		// the results are cached, a change behind the service is only
+		// observed by searches that bypass the cache
+		changed := `{"id":"1","name":"Changed"}`
+
+		conn := pool.Get()
+		if _, err := conn.Do("HSET", reader.documentsKey("test_index"),
+			key(d1), changed); err != nil {
+
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		results, err := reader.Search("test_index", "test", SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 || string(results[0]) == changed {
+			t.Fatalf("expected the cached result, got %s", results)
+		}
+
+		results, err = reader.SearchWithOptions("test_index", "test",
+			SearchOptions{Sort: SortRevScore, ReadYourWrites: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 || string(results[0]) != changed {
+			t.Fatalf("expected the changed result, got %s", results)
+		}
+
+		// writes of the service invalidate its cache
+		if err := reader.Index("test_index", d2, 2); err != nil {
+			t.Fatal(err)
+		}
+
+		search(2)
+
+		// writes of other processes are published
+		errs := make(chan error, 10)
+		inv, err := reader.StartInvalidator(func(err error) {
+			errs <- err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		if err := writer.Index("test_index", d3, 3); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; ; i++ {
+			results, err := reader.Search("test_index", "test", SortRevScore)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(results) == 3 {
+				break
+			}
+
+			if i == 100 {
+				t.Fatal("expected the cache to be invalidated")
+			}
+
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		inv.Stop()
+
+		select {
+		case err := <-errs:
+			t.Fatal(err)
+		default:
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestIntersections(t *testing.T) {
+	// only prefixes indexing intersects ZSETs
+	setUp(t, PrefixesIndexing)
+	defer tearDown(t)
+
+	autocomplete.SetIntersectionTTL(10 * time.Second)
+
+	docs := []doc{
+		{DocID: "1", Name: "New York"},
+		{DocID: "2", Name: "New Yorkshire"},
+		{DocID: "3", Name: "New Jersey"},
+	}
+
+	for i, d := range docs {
+		if err := autocomplete.Index("test_index", d, uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(query string, expected ...doc) {
+		results, err := autocomplete.Search("test_index", query, SortRevScore)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := []doc{}
+		for _, r := range results {
+			var d doc
+			if err := json.Unmarshal(r, &d); err != nil {
+				t.Fatal(err)
+			}
+
+			found = append(found, d)
+		}
+
+		if !reflect.DeepEqual(found, append([]doc{}, expected...)) {
+			t.Fatalf("expected %v for %q, got %v", expected, query, found)
+		}
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	zkey := func(terms ...string) string {
+		return autocomplete.indexKey(intersectionKind, "test_index", terms...)
+	}
+
+	search("new yo", docs[1], docs[0])
+
+	// the intersection is stored with the TTL
+	ttl, err := redis.Int64(conn.Do("PTTL", zkey("new", "yo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl <= 0 || ttl > 10000 {
+		t.Fatalf("expected a TTL of up to 10s, got %dms", ttl)
+	}
+
+	// a fresh intersection is reused
+	if _, err := conn.Do("ZREM", zkey("new", "yo"), key(docs[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	search("new yo", docs[1])
+
+	// adding characters narrows the previous intersection
+	search("new yor", docs[1])
+	search("new york", docs[1])
+
+	// activity does not change the ZSETs, the intersections are kept
+	if err := autocomplete.Touch("test_index", docs[1],
+		time.Now()); err != nil {
+
+		t.Fatal(err)
+	}
+
+	kept := func() {
+		exists, err := redis.Bool(conn.Do("EXISTS", zkey("new", "yo")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !exists {
+			t.Fatal("expected the intersection to be kept")
+		}
+	}
+
+	kept()
+
+	// neither do the selections of a user
+	if err := autocomplete.RecordUserSelection("test_index", "user",
+		docs[1]); err != nil {
+
+		t.Fatal(err)
+	}
+
+	if err := autocomplete.ClearRecentSelections("test_index",
+		"user"); err != nil {
+
+		t.Fatal(err)
+	}
+
+	kept()
+
+	// writes are not hidden by stored intersections
+	d4 := doc{DocID: "4", Name: "New Yorker"}
+	if err := autocomplete.Index("test_index", d4, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	search("new yo", d4, docs[1], docs[0])
+
+	// writes delete the stored intersections
+	if err := autocomplete.RemoveDocument("test_index", d4); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := redis.Int(conn.Do("EXISTS", zkey("new", "yo"),
+		autocomplete.intersectionsKey("test_index")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 0 {
+		t.Fatalf("expected the intersections to be deleted, got %d keys", n)
+	}
+}
+
+func TestServerSideSearch(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		client := newAutocomplete(t, testSource{pool}, indexType)
+
+		if err := autocomplete.SetServerSideSearch(true); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 30; i++ {
+			s := strconv.Itoa(i)
+			d := doc{
+				DocID: s,
+				Name:  "Alpha beta " + s,
+			}
+
+			// every seventh document expired
+			expiry := time.Time{}
+			if i%7 == 0 {
+				expiry = time.Now().Add(-time.Minute)
+			}
+
+			if err := autocomplete.IndexWithExpiry("test_index", d,
+				uint64(i%5), expiry); err != nil {
+
+				t.Fatal(err)
+			}
+		}
+
+		for _, query := range []string{"al", "alpha b", "alpha be", "beta",
+			"alpha beta 1", "gamma"} {
+
+			for _, sort := range []int{SortLexicographical,
+				SortRevLexicographical, SortScore, SortRevScore} {
+
+				for _, limit := range []int{0, 1, 5} {
+					opts := SearchOptions{Sort: sort, Limit: limit}
+
+					expected, err := client.SearchWithOptions("test_index",
+						query, opts)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					results, err := autocomplete.SearchWithOptions(
+						"test_index", query, opts)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if !reflect.DeepEqual(results, expected) {
+						t.Fatalf("expected %s for %q %+v, got %s", expected,
+							query, opts, results)
+					}
+
+					if limit > 0 && len(results) > limit {
+						t.Fatalf("expected up to %d results, got %d", limit,
+							len(results))
+					}
+				}
+			}
+		}
+
+		tearDown(t)
+	}
+}
+
+// benchmarkSearch searches an index of 100000 documents with two word
+// queries, in the server side script or on the client
+func benchmarkSearch(b *testing.B, serverSide bool, sort, limit int) {
+	b.StopTimer()
+	setUp(b, PrefixesIndexing)
+	defer tearDown(b)
+
+	if err := autocomplete.SetServerSideSearch(serverSide); err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < 100000; i++ {
+		s := strconv.Itoa(i)
+
+		d := doc{
+			DocID: s,
+			Name:  s + " " + "test_string" + s,
+		}
+
+		if err := autocomplete.Index("test_index", d, 100); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		s := strconv.Itoa(i)
+
+		b.StartTimer()
+		if _, err := autocomplete.SearchWithOptions("test_index", s+" t",
+			SearchOptions{Sort: sort, Limit: limit}); err != nil {
+
+			b.Fatal(err)
+		}
+	}
+
+	b.StopTimer()
+}
+
+func BenchmarkSearchClientSideLexicographicalSort(b *testing.B) {
+	benchmarkSearch(b, false, SortLexicographical, 0)
+}
+
+func BenchmarkSearchServerSideLexicographicalSort(b *testing.B) {
+	benchmarkSearch(b, true, SortLexicographical, 0)
+}
+
+func BenchmarkSearchClientSideScoreSortLimit(b *testing.B) {
+	benchmarkSearch(b, false, SortScore, 10)
+}
+
+func BenchmarkSearchServerSideScoreSortLimit(b *testing.B) {
+	benchmarkSearch(b, true, SortScore, 10)
+}
+
+func TestScripts(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		ctx := context.Background()
+
+		conn := pool.Get()
+		if _, err := conn.Do("SCRIPT", "FLUSH"); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		statuses, err := autocomplete.CheckScripts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(statuses) != 1 || statuses[0].Healthy() ||
+			!reflect.DeepEqual(statuses[0].Missing,
+				autocomplete.scriptNames()) {
+
+			t.Fatalf("expected all of the scripts missing, got %+v",
+				statuses)
+		}
+
+		if err := autocomplete.LoadScripts(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		statuses, err = autocomplete.CheckScripts(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(statuses) != 1 || !statuses[0].Healthy() {
+			t.Fatalf("expected the scripts loaded, got %+v", statuses)
+		}
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		if err := autocomplete.LoadScripts(cancelled); err != context.Canceled {
+			t.Fatalf("expected the context error, got %v", err)
+		}
+
+		// scripts are reloaded after a flush
+		conn = pool.Get()
+		if _, err := conn.Do("SCRIPT", "FLUSH"); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+
+		d := doc{
+			DocID: "1",
+			Name:  "Test one",
+		}
+
+		if err := autocomplete.Index("test_index", d, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.RecordSelection("test_index", "test",
+			d); err != nil {
+
+			t.Fatal(err)
+		}
+
+		if err := autocomplete.RemoveDocument("test_index", d); err != nil {
+			t.Fatal(err)
+		}
+
+		// scripts that do not find the document report the missing key
+		if err := autocomplete.RecordSelection("test_index", "test",
+			d); !errors.Is(err, ErrKeyNotFound) {
+
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+
+		tearDown(t)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		d1 := doc{
+			DocID: "1",
+			Name:  "Test one",
+		}
+
+		d2 := doc{
+			DocID: "2",
+			Name:  "Test two",
+		}
+
+		check := func(err, kind error) {
+			if !errors.Is(err, kind) {
+				t.Fatalf("expected %v, got %v", kind, err)
+			}
+
+			var e *Error
+			if !errors.As(err, &e) || e.Index != "test_index" {
+				t.Fatalf("expected an *Error of test_index, got %v", err)
+			}
+		}
+
+		// operations on an index without documents
+		check(autocomplete.UpdateDocument("test_index", d1), ErrIndexNotFound)
+		check(autocomplete.UpdateScore("test_index", d1, 1), ErrIndexNotFound)
+		check(autocomplete.RemoveDocument("test_index", d1), ErrIndexNotFound)
+		check(autocomplete.RemoveByID("test_index", "1"), ErrIndexNotFound)
+		check(autocomplete.RecordSelection("test_index", "test", d1),
+			ErrIndexNotFound)
+
+		if err := autocomplete.Index("test_index", d1, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		// operations on a document that is not indexed
+		check(autocomplete.UpdateDocument("test_index", d2),
+			ErrDocumentNotFound)
+		check(autocomplete.UpdateScore("test_index", d2, 1),
+			ErrDocumentNotFound)
+		check(autocomplete.RemoveDocument("test_index", d2),
+			ErrDocumentNotFound)
+		check(autocomplete.RemoveByID("test_index", "2"), ErrDocumentNotFound)
+		check(autocomplete.RecordSelection("test_index", "test", d2),
+			ErrDocumentNotFound)
+
+		// prefixes indexing re-indexes documents
+		err := autocomplete.Index("test_index", d1, 2)
+		if indexType == TermsIndexing {
+			check(err, ErrAlreadyIndexed)
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		// the errors of the commands of a transaction are returned
+		conn := pool.Get()
+		if _, err := conn.Do("SET", autocomplete.termsKey("test_index"),
+			"x"); err != nil {
+
+			t.Fatal(err)
+		}
+
+		conn.Close()
+
+		if err := autocomplete.Index("test_index", d2, 1); err == nil ||
+			!strings.HasPrefix(err.Error(), "WRONGTYPE") {
+
+			t.Fatalf("expected a WRONGTYPE error, got %v", err)
+		}
+
+		tearDown(t)
+	}
+
+	// a server that can not be reached
+	unreachable := &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", "127.0.0.1:1")
+		},
+	}
+
+	a := newAutocomplete(t, testSource{unreachable}, PrefixesIndexing)
+
+	_, err := a.Search("test_index", "test", SortScore)
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Fatalf("expected ErrBackendUnavailable, got %v", err)
+	}
+
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("expected the network error to be wrapped, got %v", err)
+	}
+}
+
+type searchMetrics struct {
+	searches int
+	cached   int
+	results  int
+}
+
+func (m *searchMetrics) ObserveSearch(index string, duration time.Duration,
+	results int, cached bool, err error) {
+
+	m.searches++
+	m.results += results
+	if cached {
+		m.cached++
+	}
+}
+
+func TestNewOptions(t *testing.T) {
+	for _, indexType := range []int{PrefixesIndexing, TermsIndexing} {
+		setUp(t, indexType)
+
+		metrics := &searchMetrics{}
+		hyphens := func(text string) []string {
+			return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+				return r == '-' || r == ' '
+			})
+		}
+
+		a := newAutocomplete(t, testSource{pool}, indexType,
+			WithAnalyzer(hyphens), WithBatchSize(2), WithCache(100, time.Minute),
+			WithMetrics(metrics))
+
+		expected := []doc{}
+		for i := 1; i <= 5; i++ {
+			d := doc{
+				DocID: strconv.Itoa(i),
+				Name:  "Jean-Luc " + strconv.Itoa(i),
+			}
+
+			if err := a.Index("test_index", d, uint64(i)); err != nil {
+				t.Fatal(err)
+			}
+
+			expected = append(expected, d)
+		}
+
+		search := func(query string, expected []doc) {
+			results, err := a.Search("test_index", query, SortScore)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			docs := []doc{}
+			for _, b := range results {
+				var d doc
+				if err := json.Unmarshal(b, &d); err != nil {
+					t.Fatal(err)
+				}
+
+				docs = append(docs, d)
+			}
+
+			if !reflect.DeepEqual(docs, expected) {
+				t.Fatalf("%q: expected %v, got %v", query, expected, docs)
+			}
+		}
+
+		// the results are fetched in batches of 2 documents
+		search("jean-l", expected)
+		search("jean-l", expected)
+
+		// prefixes indexing indexes the hyphenated words separately
+		if indexType == PrefixesIndexing {
+			search("luc", expected)
+		}
+
+		if metrics.searches < 2 || metrics.cached != 1 ||
+			metrics.results != len(expected)*metrics.searches {
+
+			t.Fatalf("unexpected metrics %+v", *metrics)
+		}
+
+		tearDown(t)
+	}
+
+	_, err := New(testSource{pool}, prefix, 2)
+	if !errors.Is(err, ErrInvalidIndexType) {
+		t.Fatalf("expected ErrInvalidIndexType, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package autocomplete
+
+import (
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultIntersectionTTL is the time the intersection of a multiple word
+// search is stored for reuse by later searches
+const DefaultIntersectionTTL = time.Minute
+
+// SetIntersectionTTL sets the time the intersections of multiple word
+// searches are stored. intersections are reused by searches for the same
+// words and narrowed by searches that add characters to the last word until
+// the index is written to.
+func (a *Autocomplete) SetIntersectionTTL(ttl time.Duration) {
+	a.intersectionTTL = ttl
+}
+
+// intersectionsKey is the key of the SET of the stored intersections of an
+// index, which are deleted by writes to the index
+func (a *Autocomplete) intersectionsKey(index string) string {
+	return a.indexKey(intersectionsKind, index)
+}
+
+// dropIntersections deletes the stored intersections of an index
+func (a *Autocomplete) dropIntersections(conn *redisConn, index string) error {
+	_, err := a.runScript(conn, "dropIntersections", a.intersectionsKey(index))
+	return err
+}
+
+// previousTerms returns the words of the query before the last character was
+// added, word prefixes are indexed up to the first byte of every character
+func previousTerms(terms []string) []string {
+	last := terms[len(terms)-1]
+	previous := append([]string{}, terms[:len(terms)-1]...)
+
+	if head := last[:len(last)-1]; head != "" {
+		_, size := utf8.DecodeLastRuneInString(head)
+		previous = append(previous, head[:len(head)-size+1])
+	}
+
+	return previous
+}
+
+// intersectionMillis returns the TTL of stored intersections in milliseconds
+func (a *Autocomplete) intersectionMillis() int64 {
+	if a.intersectionTTL < time.Millisecond {
+		return 1
+	}
+
+	return int64(a.intersectionTTL / time.Millisecond)
+}
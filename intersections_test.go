@@ -0,0 +1,22 @@
+package autocomplete
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreviousTerms(t *testing.T) {
+	for terms, expected := range map[string][]string{
+		"new yor": {"new", "yo"},
+		"new y":   {"new"},
+		"a b c":   {"a", "b"},
+		"hél":     {"h\xc3"},
+		"hé":      {"h\xc3"},
+	} {
+		if p := previousTerms(queryTerms(terms)); !reflect.DeepEqual(p,
+			expected) {
+
+			t.Fatalf("expected %q for %q, got %q", expected, terms, p)
+		}
+	}
+}
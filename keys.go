@@ -0,0 +1,402 @@
+package autocomplete
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// KeySchemaLegacy builds the documents, terms indexing, prefixes and
+	// intersection keys by concatenating the prefix, a kind marker and the
+	// index name, e.g. "prefix:$index" and "prefix:index:p", and terms
+	// indexing members as "term::score::docKey". keys of the other kinds are
+	// built as in KeySchemaEscaped.
+	//
+	// index names, terms and document keys are not escaped so different
+	// indexes can share keys and members can be parsed incorrectly.
+	KeySchemaLegacy = 1
+
+	// KeySchemaEscaped builds keys as colon separated fields: the prefix, the
+	// schema version, the key kind, the index name and the kind's
+	// parameters, e.g. "prefix:2:$:index" and "prefix:2:p:index:p". every
+	// field except the prefix is escaped, so keys of different indexes and
+	// kinds never collide.
+	//
+	// document keys are "term:id" and terms indexing members are
+	// "term:score:docKey" with the fields escaped and the score as 16 hex
+	// digits, so members sort by term and then by score.
+	KeySchemaEscaped = 2
+)
+
+// key kinds, the legacy schema builds the keys of the kinds it was created
+// with by concatenation and the keys of the rest in the escaped layout
+const (
+	prefixesKind      = "p"
+	documentsKind     = "$"
+	termsKind         = "$$"
+	storedTermsKind   = "$t"
+	phoneticKind      = "#"
+	activityKind      = "$a"
+	decayStartKind    = "$ad"
+	clicksKind        = "$c"
+	recentKind        = "$u"
+	queryBucketKind   = "$q"
+	queriesKind       = "$qq"
+	queryCountsKind   = "$qn"
+	queryTempKind     = "$qt"
+	expiryKind        = "$e"
+	idsKind           = "$i"
+	intersectionKind  = "$x"
+	intersectionsKind = "$xs"
+)
+
+// legacyKinds are the key kinds of the legacy schema that are not built in
+// the escaped layout
+var legacyKinds = map[string]bool{
+	documentsKind:    true,
+	termsKind:        true,
+	prefixesKind:     true,
+	intersectionKind: true,
+}
+
+// keyEscaper escapes the field separator, the escape character and the Redis
+// Cluster hash tag braces
+var keyEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "{", "%7B",
+	"}", "%7D")
+
+var keyUnescaper = strings.NewReplacer("%25", "%", "%3A", ":", "%7B", "{",
+	"%7D", "}")
+
+// SetKeySchema sets the key schema version used to build keys and members,
+// the default is KeySchemaLegacy. indexes stored with another schema should
+// be migrated with MigrateKeySchema.
+func (a *Autocomplete) SetKeySchema(version int) error {
+	if version != KeySchemaLegacy && version != KeySchemaEscaped {
+		return fmt.Errorf("invalid key schema version %d", version)
+	}
+
+	a.keySchema = version
+	return nil
+}
+
+// KeySchemaVersion returns the key schema version stored in Redis, which is
+// recorded when documents are first indexed and by MigrateKeySchema. it is
+// KeySchemaLegacy when no version is stored.
+func (a *Autocomplete) KeySchemaVersion() (int, error) {
+	conn := a.conn()
+	defer conn.Close()
+
+	v, err := replyInt(conn.Do("GET", a.schemaVersionKey()))
+	if err == errNil {
+		return KeySchemaLegacy, nil
+	}
+
+	return v, err
+}
+
+// schemaVersionKey is the key of the stored key schema version, it is the
+// same in every schema
+func (a *Autocomplete) schemaVersionKey() string {
+	return a.prefix + ":schema"
+}
+
+// recordKeySchema stores the key schema version before documents are first
+// indexed, legacy indexes are recognized by the missing version. the version
+// key is not in the hash slot of any index, so it is written outside of the
+// index transactions
+func (a *Autocomplete) recordKeySchema(conn *redisConn) error {
+	if a.keySchema == KeySchemaLegacy {
+		return nil
+	}
+
+	_, err := conn.Do("SETNX", a.schemaVersionKey(), a.keySchema)
+	return err
+}
+
+// indexKey returns the key of the given kind of an index in the configured
+// key schema
+func (a *Autocomplete) indexKey(kind, index string, params ...string) string {
+	return buildKey(a.keySchema, a.hashTags, a.prefix, kind, index, params...)
+}
+
+// documentsKey is the key of the hash of the stored documents of an index
+func (a *Autocomplete) documentsKey(index string) string {
+	return a.indexKey(documentsKind, index)
+}
+
+// prefixKey is the key of the prefixes indexing ZSET of a word prefix
+func (a *Autocomplete) prefixKey(index, p string) string {
+	return a.indexKey(prefixesKind, index, p)
+}
+
+// termsIndexKey is the key of the terms indexing ZSET of an index
+func (a *Autocomplete) termsIndexKey(index string) string {
+	return a.indexKey(termsKind, index)
+}
+
+// buildKey builds a key in a key schema, a tagged key has the escaped index
+// name as its hash tag
+func buildKey(version int, tagged bool, prefix, kind, index string,
+	params ...string) string {
+
+	tag := keyEscaper.Replace(index)
+	if tagged {
+		tag = "{" + tag + "}"
+	}
+
+	if version == KeySchemaLegacy && legacyKinds[kind] {
+		if tagged {
+			index = tag
+		}
+
+		switch kind {
+		case prefixesKind:
+			return prefix + ":" + index + ":" + strings.Join(params, ":")
+
+		case intersectionKind:
+			return prefix + ":$" + index + ":" + strings.Join(params, "|")
+		}
+
+		return prefix + ":" + kind + index
+	}
+
+	k := prefix + ":" + strconv.Itoa(KeySchemaEscaped) + ":" + kind + ":" + tag
+	for _, p := range params {
+		k += ":" + keyEscaper.Replace(p)
+	}
+
+	return k
+}
+
+// encodeMember returns the terms indexing ZSET member of a document
+func (a *Autocomplete) encodeMember(term string, score uint64,
+	docKey string) (string, error) {
+
+	return encodeMember(a.keySchema, term, score, docKey)
+}
+
+func encodeMember(version int, term string, score uint64,
+	docKey string) (string, error) {
+
+	term = strings.ToLower(term)
+
+	if version == KeySchemaLegacy {
+		scoreStr, err := scoreString(score)
+		if err != nil {
+			return "", err
+		}
+
+		return term + "::" + scoreStr + "::" + docKey, nil
+	}
+
+	return keyEscaper.Replace(term) + ":" + fmt.Sprintf("%016x", score) + ":" +
+		keyEscaper.Replace(docKey), nil
+}
+
+// decodeMember returns the term, score and document key of a terms indexing
+// ZSET member
+func (a *Autocomplete) decodeMember(member string) (string, uint64, string,
+	error) {
+
+	return decodeMember(a.keySchema, member)
+}
+
+func decodeMember(version int, member string) (string, uint64, string,
+	error) {
+
+	if version == KeySchemaLegacy {
+		parts := strings.Split(member, "::")
+		if len(parts) < 3 {
+			return "", 0, "", fmt.Errorf("invalid member %s", member)
+		}
+
+		score, err := parseScoreString(parts[len(parts)-2])
+		if err != nil {
+			return "", 0, "", err
+		}
+
+		return strings.Join(parts[:len(parts)-2], "::"), score,
+			parts[len(parts)-1], nil
+	}
+
+	parts := strings.Split(member, ":")
+	if len(parts) != 3 {
+		return "", 0, "", fmt.Errorf("invalid member %s", member)
+	}
+
+	score, err := strconv.ParseUint(parts[1], 16, 64)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	return keyUnescaper.Replace(parts[0]), score,
+		keyUnescaper.Replace(parts[2]), nil
+}
+
+// memberSuffix returns the suffix of the terms indexing ZSET members of a
+// document
+func (a *Autocomplete) memberSuffix(docKey string) string {
+	if a.keySchema == KeySchemaLegacy {
+		return "::" + docKey
+	}
+
+	return ":" + keyEscaper.Replace(docKey)
+}
+
+// prefixRange returns the ZRANGEBYLEX range of the terms indexing ZSET
+// members whose terms start with the given prefix
+func (a *Autocomplete) prefixRange(prefix string) (string, string) {
+	p := strings.ToLower(prefix)
+	if a.keySchema != KeySchemaLegacy {
+		p = keyEscaper.Replace(p)
+	}
+
+	return "[" + p, "[" + p + "\xff"
+}
+
+// termRange returns the ZRANGEBYLEX range of the terms indexing ZSET members
+// of the given term
+func (a *Autocomplete) termRange(term string) (string, string) {
+	if a.keySchema == KeySchemaLegacy {
+		return a.prefixRange(term + "::")
+	}
+
+	t := keyEscaper.Replace(strings.ToLower(term)) + ":"
+	return "[" + t, "[" + t + "\xff"
+}
+
+// MigrateKeySchema moves the keys of the given indexes from the legacy key
+// schema to KeySchemaEscaped, records the new version in Redis and switches
+// the service to it. documents of the migrated indexes keep their legacy
+// document keys.
+//
+// writes to the indexes should be stopped during the migration, and other
+// services must switch to the new schema with SetKeySchema. legacy keys are
+// ambiguous, the keys of an index named like another index followed by ':'
+// are migrated with both indexes.
+func (a *Autocomplete) MigrateKeySchema(indexes ...string) error {
+	if a.keySchema != KeySchemaLegacy {
+		return fmt.Errorf("key schema is already version %d", a.keySchema)
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	for _, index := range indexes {
+		if err := a.migrateIndexKeys(conn, index); err != nil {
+			return err
+		}
+	}
+
+	if _, err := conn.Do(
+		"SET", a.schemaVersionKey(), KeySchemaEscaped); err != nil {
+
+		return err
+	}
+
+	a.keySchema = KeySchemaEscaped
+	return nil
+}
+
+func (a *Autocomplete) migrateIndexKeys(conn *redisConn, index string) error {
+	from := func(kind string, params ...string) string {
+		return buildKey(KeySchemaLegacy, a.hashTags, a.prefix, kind, index,
+			params...)
+	}
+
+	to := func(kind string, params ...string) string {
+		return buildKey(KeySchemaEscaped, a.hashTags, a.prefix, kind, index,
+			params...)
+	}
+
+	// only the keys of the legacy kinds are moved, the keys of the other kinds
+	// are in the escaped layout in both schemas
+	if err := renameKey(conn, from(documentsKind),
+		to(documentsKind)); err != nil {
+
+		return err
+	}
+
+	// the prefixes keys are collected before they are renamed and keys that
+	// are already escaped are skipped
+	escaped := a.prefix + ":" + strconv.Itoa(KeySchemaEscaped) + ":"
+
+	base := from(prefixesKind, "")
+	keys := []string{}
+
+	if err := scan(conn, "SCAN", "", globEscape(base)+"*",
+		func(values []string) error {
+			for _, k := range values {
+				if !strings.HasPrefix(k, escaped) {
+					keys = append(keys, k)
+				}
+			}
+
+			return nil
+		}); err != nil {
+
+		return err
+	}
+
+	for _, k := range keys {
+		if err := renameKey(conn, k,
+			to(prefixesKind, strings.TrimPrefix(k, base))); err != nil {
+
+			return err
+		}
+	}
+
+	// terms indexing members are encoded differently
+	tkey := from(termsKind)
+	if err := scan(conn, "ZSCAN", tkey, "", func(values []string) error {
+		args := []interface{}{to(termsKind)}
+		for i := 0; i < len(values); i += 2 {
+			term, score, docKey, err := decodeMember(KeySchemaLegacy, values[i])
+			if err != nil {
+				return err
+			}
+
+			member, err := encodeMember(KeySchemaEscaped, term, score, docKey)
+			if err != nil {
+				return err
+			}
+
+			args = append(args, 0, member)
+		}
+
+		if len(args) == 1 {
+			return nil
+		}
+
+		_, err := conn.Do("ZADD", args...)
+		return err
+	}); err != nil {
+
+		return err
+	}
+
+	if _, err := conn.Do("DEL", tkey); err != nil {
+		return err
+	}
+
+	// the documents keep their legacy keys, which the layout records
+	l, err := a.loadLayout(conn, index)
+	if err != nil {
+		return err
+	}
+
+	l.docKeys = KeySchemaLegacy
+	return a.storeLayout(conn, index, l)
+}
+
+// renameKey renames a key if it exists
+func renameKey(conn *redisConn, from, to string) error {
+	exists, err := replyBool(conn.Do("EXISTS", from))
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = conn.Do("RENAME", from, to)
+	return err
+}
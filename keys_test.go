@@ -0,0 +1,128 @@
+package autocomplete
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestBuildKey(t *testing.T) {
+	legacy := func(kind string, params ...string) string {
+		return buildKey(KeySchemaLegacy, false, "ac", kind, "idx", params...)
+	}
+
+	expected := map[string]string{
+		legacy(documentsKind):              "ac:$idx",
+		legacy(termsKind):                  "ac:$$idx",
+		legacy(prefixesKind, "te"):         "ac:idx:te",
+		legacy(clicksKind, "q"):            "ac:2:$c:idx:q",
+		legacy(intersectionKind, "a", "b"): "ac:$idx:a|b",
+	}
+
+	for k, e := range expected {
+		if k != e {
+			t.Errorf("expected %s, got %s", e, k)
+		}
+	}
+
+	// legacy keys of different indexes collide
+	if buildKey(KeySchemaLegacy, false, "ac", documentsKind, "$idx") !=
+		buildKey(KeySchemaLegacy, false, "ac", termsKind, "idx") {
+
+		t.Fail()
+	}
+
+	if buildKey(KeySchemaEscaped, false, "ac", documentsKind, "$idx") ==
+		buildKey(KeySchemaEscaped, false, "ac", termsKind, "idx") {
+
+		t.Fail()
+	}
+
+	// kinds added to the legacy schema do not collide with its documents
+	for _, kind := range []string{storedTermsKind, activityKind,
+		decayStartKind, expiryKind, idsKind, queriesKind, clicksKind,
+		intersectionsKind} {
+
+		if buildKey(KeySchemaLegacy, false, "ac", kind, "idx") ==
+			buildKey(KeySchemaLegacy, false, "ac", documentsKind,
+				kind[1:]+"idx") {
+
+			t.Errorf("the %s key collides with a documents key", kind)
+		}
+	}
+
+	if k := buildKey(KeySchemaEscaped, false, "ac", prefixesKind, "a:b",
+		"{c}%"); k != "ac:2:p:a%3Ab:%7Bc%7D%25" {
+
+		t.Errorf("unexpected key %s", k)
+	}
+
+	if buildKey(KeySchemaEscaped, false, "ac", prefixesKind, "a:b", "c") ==
+		buildKey(KeySchemaEscaped, false, "ac", prefixesKind, "a", "b:c") {
+
+		t.Fail()
+	}
+}
+
+func TestEncodeMember(t *testing.T) {
+	for _, version := range []int{KeySchemaLegacy, KeySchemaEscaped} {
+		m, err := encodeMember(version, "Test Term", 42, "test_term_1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		term, score, docKey, err := decodeMember(version, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if term != "test term" || score != 42 || docKey != "test_term_1" {
+			t.Errorf("unexpected decoded member %s %d %s", term, score, docKey)
+		}
+	}
+
+	m, err := encodeMember(KeySchemaEscaped, "a::b", 1, "a::b_c:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	term, score, docKey, err := decodeMember(KeySchemaEscaped, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if term != "a::b" || score != 1 || docKey != "a::b_c:1" {
+		t.Errorf("unexpected decoded member %s %d %s", term, score, docKey)
+	}
+
+	if _, _, _, err := decodeMember(KeySchemaEscaped, "a:b"); err == nil {
+		t.Error("expected an error decoding an invalid member")
+	}
+}
+
+func TestEscapedMembersOrder(t *testing.T) {
+	members := []string{}
+	for _, s := range []uint64{300, 2, 1 << 40, 10} {
+		m, err := encodeMember(KeySchemaEscaped, "term", s, "term_1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		members = append(members, m)
+	}
+
+	sort.Strings(members)
+
+	prev := uint64(0)
+	for _, m := range members {
+		_, s, _, err := decodeMember(KeySchemaEscaped, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s < prev {
+			t.Errorf("members are not ordered by score %v", members)
+		}
+
+		prev = s
+	}
+}
@@ -1,85 +1,33 @@
 package autocomplete
 
-import "github.com/garyburd/redigo/redis"
-
 func (a *Autocomplete) initScripts() {
-	a.scripts["removeDocument"] = redis.NewScript(2, `
+	// members are matched by the suffix of the document key, which depends on
+	// the key schema
+	a.addScript("removeDocument", 1, `
 			local a={}
 			local zkey=KEYS[1]
-			local key=KEYS[2]
-			
-			local split_func=function(str, delim, maxNb)
-				-- Eliminate bad cases...
-			    if string.find(str, delim) == nil then
-			        return { str }
-			    end
-			    if maxNb == nil or maxNb < 1 then
-			        maxNb = 0    -- No limit
-			    end
-			    local result = {}
-			    local pat = "(.-)" .. delim .. "()"
-			    local nb = 0
-			    local lastPos
-			    for part, pos in string.gfind(str, pat) do
-			        nb = nb + 1
-			        result[nb] = part
-			        lastPos = pos
-			        if nb == maxNb then break end
-			    end
-			    -- Handle the last field
-			    if nb ~= maxNb then
-			        result[nb + 1] = string.sub(str, lastPos)
-			    end
-			    return result
-			end
+			local suffix=ARGV[1]
 
 			a=redis.call("ZRANGE", zkey, 0, -1)
 			for i=1,#a do
-				local parts=split_func(a[i], "::", 0)
-				local akey=parts[table.getn(parts)]
-				if akey == key then return a[i] end
+				if string.sub(a[i], -string.len(suffix)) == suffix then
+					return a[i]
+				end
 			end
 			
 			return redis.error_reply("key not found in zset")
 	`)
 
-	a.scripts["updateScore"] = redis.NewScript(3, `
+	a.addScript("updateScore", 1, `
 			local a={}
 			local zkey=KEYS[1]
-			local key=KEYS[2]
-			local val=KEYS[3]
-			
-			local split_func=function(str, delim, maxNb)
-				-- Eliminate bad cases...
-			    if string.find(str, delim) == nil then
-			        return { str }
-			    end
-			    if maxNb == nil or maxNb < 1 then
-			        maxNb = 0    -- No limit
-			    end
-			    local result = {}
-			    local pat = "(.-)" .. delim .. "()"
-			    local nb = 0
-			    local lastPos
-			    for part, pos in string.gfind(str, pat) do
-			        nb = nb + 1
-			        result[nb] = part
-			        lastPos = pos
-			        if nb == maxNb then break end
-			    end
-			    -- Handle the last field
-			    if nb ~= maxNb then
-			        result[nb + 1] = string.sub(str, lastPos)
-			    end
-			    return result
-			end
+			local suffix=ARGV[1]
+			local val=ARGV[2]
 
 			local member=""
 			a=redis.call("ZRANGE", zkey, 0, -1)
 			for i=1,#a do
-				local parts=split_func(a[i], "::", 0)
-				local akey=parts[table.getn(parts)]
-				if akey == key then 
+				if string.sub(a[i], -string.len(suffix)) == suffix then
 					member=a[i] 
 					break
 				end
@@ -92,4 +40,309 @@ func (a *Autocomplete) initScripts() {
 			redis.call("ZREM", zkey, member)
 			redis.call("ZADD", zkey, 0, val)
 	`)
+
+	a.addScript("incrementScore", -1, `
+			local hkey=KEYS[1]
+			local key=ARGV[1]
+			local increment=ARGV[2]
+
+			if redis.call("HEXISTS", hkey, key) == 0 then
+				return redis.error_reply("key not found in hash")
+			end
+
+			local score=0
+			for i=2,#KEYS do
+				score=redis.call("ZINCRBY", KEYS[i], increment, key)
+			end
+
+			return score
+	`)
+
+	a.addScript("hdelIfEquals", 1, `
+			local hkey=KEYS[1]
+			local field=ARGV[1]
+			local val=ARGV[2]
+
+			if redis.call("HGET", hkey, field) == val then
+				return redis.call("HDEL", hkey, field)
+			end
+
+			return 0
+	`)
+
+	a.addScript("addIfStored", -1, `
+			local hkey=KEYS[1]
+			local key=ARGV[1]
+			local score=ARGV[2]
+			local nx=ARGV[3]
+
+			if redis.call("HEXISTS", hkey, key) == 0 then
+				return 0
+			end
+
+			for i=2,#KEYS do
+				if nx ~= "1" or not redis.call("ZSCORE", KEYS[i], key) then
+					redis.call("ZADD", KEYS[i], score, key)
+				end
+			end
+
+			return #KEYS-1
+	`)
+
+	// the queries of the completion ZSETs are trimmed to the most executed,
+	// the ZSETs expire when no query is logged for the retention
+	a.addScript("recordQuery", 3, `
+			local bkey=KEYS[1]
+			local qkey=KEYS[2]
+			local ckey=KEYS[3]
+			local q=ARGV[1]
+			local expireAt=ARGV[2]
+			local size=tonumber(ARGV[3])
+			local ttl=tonumber(ARGV[4])
+
+			redis.call("ZINCRBY", bkey, 1, q)
+			redis.call("EXPIREAT", bkey, expireAt)
+
+			redis.call("ZADD", qkey, 0, q)
+			redis.call("ZINCRBY", ckey, 1, q)
+
+			local n=redis.call("ZCARD", ckey)
+			if size > 0 and n > size then
+				local a=redis.call("ZRANGE", ckey, 0, n-size-1)
+				redis.call("ZREMRANGEBYRANK", ckey, 0, n-size-1)
+				for i=1,#a do
+					redis.call("ZREM", qkey, a[i])
+				end
+			end
+
+			if ttl > 0 then
+				redis.call("PEXPIRE", qkey, ttl)
+				redis.call("PEXPIRE", ckey, ttl)
+			end
+
+			return n
+	`)
+
+	// elements are given as pairs of an element and its document key, only
+	// elements of documents that are not stored are removed
+	a.addScript("removeOrphans", 2, `
+			local hkey=KEYS[1]
+			local key=KEYS[2]
+			local cmd=ARGV[1]
+			local n=0
+
+			for i=2,#ARGV,2 do
+				if redis.call("HEXISTS", hkey, ARGV[i+1]) == 0 then
+					n=n+redis.call(cmd, key, ARGV[i])
+				end
+			end
+
+			return n
+	`)
+
+	a.addScript("setTermsMember", 2, `
+			local zkey=KEYS[1]
+			local hkey=KEYS[2]
+			local key=ARGV[1]
+			local suffix=ARGV[2]
+			local min=ARGV[3]
+			local max=ARGV[4]
+			local val=ARGV[5]
+			local mode=ARGV[6]
+
+			if mode ~= "rm" and redis.call("HEXISTS", hkey, key) == 0 then
+				return 0
+			end
+
+			local a=redis.call("ZRANGEBYLEX", zkey, min, max)
+			for i=1,#a do
+				if string.sub(a[i], -string.len(suffix)) == suffix then
+					if mode == "nx" then return 0 end
+					redis.call("ZREM", zkey, a[i])
+				end
+			end
+
+			if mode ~= "rm" then
+				redis.call("ZADD", zkey, 0, val)
+			end
+
+			return 1
+	`)
+
+	// the intersection is reused while it exists, otherwise it is computed
+	// from the intersection of the previous query when that exists, expires
+	// after ttl milliseconds and is tracked in the SET of intersections, which
+	// outlives its members
+	a.addScript("intersectRange", -1, `
+			local zkey=KEYS[1]
+			local skey=KEYS[2]
+			local previous=KEYS[3]
+			local ttl=tonumber(ARGV[1])
+			local order=ARGV[2]
+
+			if redis.call("EXISTS", zkey) == 0 then
+				local args={zkey}
+				if previous ~= zkey and redis.call("EXISTS", previous) == 1 then
+					args[#args+1]=2
+					args[#args+1]=previous
+					args[#args+1]=KEYS[#KEYS]
+				else
+					args[#args+1]=#KEYS-3
+					for i=4,#KEYS do
+						args[#args+1]=KEYS[i]
+					end
+				end
+
+				args[#args+1]="AGGREGATE"
+				args[#args+1]="MAX"
+
+				if redis.call("ZINTERSTORE", unpack(args)) == 0 then
+					return {}
+				end
+
+				redis.call("PEXPIRE", zkey, ttl)
+				redis.call("SADD", skey, zkey)
+				if redis.call("PTTL", skey) < ttl then
+					redis.call("PEXPIRE", skey, ttl)
+				end
+			end
+
+			if order == "revlex" then
+				return redis.call("ZREVRANGE", zkey, 0, -1)
+			elseif order == "score" then
+				return redis.call("ZRANGEBYSCORE", zkey, "-inf", "+inf")
+			elseif order == "revscore" then
+				return redis.call("ZREVRANGEBYSCORE", zkey, "+inf", "-inf")
+			end
+
+			return redis.call("ZRANGE", zkey, 0, -1)
+	`)
+
+	// a search of multiple words stores its intersection like intersectRange,
+	// the members are ranged up to the limit skipping expired documents and
+	// their documents are returned
+	a.addScript("search", -1, `
+			local zkey=KEYS[1]
+			local skey=KEYS[2]
+			local previous=KEYS[3]
+			local hkey=KEYS[4]
+			local ekey=KEYS[5]
+			local ttl=tonumber(ARGV[1])
+			local order=ARGV[2]
+			local limit=tonumber(ARGV[3])
+			local now=tonumber(ARGV[4])
+
+			if #KEYS > 6 and redis.call("EXISTS", zkey) == 0 then
+				local args={zkey}
+				if previous ~= zkey and redis.call("EXISTS", previous) == 1 then
+					args[#args+1]=2
+					args[#args+1]=previous
+					args[#args+1]=KEYS[#KEYS]
+				else
+					args[#args+1]=#KEYS-5
+					for i=6,#KEYS do
+						args[#args+1]=KEYS[i]
+					end
+				end
+
+				args[#args+1]="AGGREGATE"
+				args[#args+1]="MAX"
+
+				if redis.call("ZINTERSTORE", unpack(args)) == 0 then
+					return {}
+				end
+
+				redis.call("PEXPIRE", zkey, ttl)
+				redis.call("SADD", skey, zkey)
+				if redis.call("PTTL", skey) < ttl then
+					redis.call("PEXPIRE", skey, ttl)
+				end
+			end
+
+			local expiring=redis.call("ZCARD", ekey) > 0
+			local keys={}
+
+			local function add(a)
+				for i=1,#a do
+					if limit > 0 and #keys >= limit then
+						return
+					end
+
+					local expiry=expiring and redis.call("ZSCORE", ekey, a[i])
+					if not expiry or tonumber(expiry) > now then
+						keys[#keys+1]=a[i]
+					end
+				end
+			end
+
+			if order == "score" or order == "revscore" then
+				local cmd, min, max="ZRANGEBYSCORE", "-inf", "+inf"
+				if order == "revscore" then
+					cmd, min, max="ZREVRANGEBYSCORE", "+inf", "-inf"
+				end
+
+				if limit > 0 then
+					local offset=0
+					local a
+					repeat
+						a=redis.call(cmd, zkey, min, max, "LIMIT", offset, limit)
+						add(a)
+						offset=offset+limit
+					until #keys >= limit or #a < limit
+				else
+					add(redis.call(cmd, zkey, min, max))
+				end
+			else
+				-- members are compared by bytes like the client does, the
+				-- server compares strings by its locale
+				local function less(x, y)
+					for i=1,math.min(#x, #y) do
+						local bx, by=string.byte(x, i), string.byte(y, i)
+						if bx ~= by then
+							return bx < by
+						end
+					end
+
+					return #x < #y
+				end
+
+				local a=redis.call("ZRANGE", zkey, 0, -1)
+				if order == "revlex" then
+					table.sort(a, function(x, y) return less(y, x) end)
+				else
+					table.sort(a, less)
+				end
+
+				add(a)
+			end
+
+			local docs={}
+			for i=1,#keys,1000 do
+				local batch={}
+				for j=i,math.min(i+999, #keys) do
+					batch[#batch+1]=keys[j]
+				end
+
+				local values=redis.call("HMGET", hkey, unpack(batch))
+				for j=1,#values do
+					if values[j] then
+						docs[#docs+1]=values[j]
+					end
+				end
+			end
+
+			return docs
+	`)
+
+	// the intersections share the hash tag of the SET
+	a.addScript("dropIntersections", 1, `
+			local skey=KEYS[1]
+
+			local a=redis.call("SMEMBERS", skey)
+			for i=1,#a do
+				redis.call("DEL", a[i])
+			end
+
+			return redis.call("DEL", skey)
+	`)
 }
@@ -0,0 +1,507 @@
+package autocomplete
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLayoutTTL is the time an index layout is cached in process
+const DefaultLayoutTTL = time.Second
+
+// migrateBatch is the number of documents copied in a single pipeline
+const migrateBatch = 100
+
+// notMigrating is the target of a layout that is not migrating
+const notMigrating = -1
+
+// layout is the indexing type of an index, while the index is migrating
+// writes maintain both the indexing type and the target type. docKeys is the
+// key schema of the index's document keys.
+type layout struct {
+	indexType int
+	target    int
+	docKeys   int
+	loaded    time.Time
+}
+
+func (l layout) migrating() bool {
+	return l.target != notMigrating
+}
+
+// SetLayoutTTL sets the time the layout of an index is cached in process,
+// every process reads the layout of every index it uses once per TTL. a
+// migration waits for twice the TTL between its phases so every process
+// observes the layout change before the migration proceeds, all processes
+// must use the same TTL.
+func (a *Autocomplete) SetLayoutTTL(ttl time.Duration) {
+	a.layoutTTL = ttl
+}
+
+// layoutsKey is the key of the hash that stores the layouts of indexes that
+// were migrated, indexes without a layout use the service's indexing type. it
+// is in the escaped layout in every key schema.
+func (a *Autocomplete) layoutsKey() string {
+	return a.prefix + ":" + strconv.Itoa(KeySchemaEscaped) + ":$l"
+}
+
+// layout returns the cached layout of an index, it is reloaded when it is
+// older than the layout TTL
+func (a *Autocomplete) layout(index string) (layout, error) {
+	a.layoutsMutex.Lock()
+	l, ok := a.layouts[index]
+	a.layoutsMutex.Unlock()
+
+	if ok && time.Since(l.loaded) < a.layoutTTL {
+		return l, nil
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	return a.loadLayout(conn, index)
+}
+
+func (a *Autocomplete) loadLayout(conn *redisConn,
+	index string) (layout, error) {
+
+	l := layout{indexType: a.indexType, target: notMigrating, loaded: time.Now()}
+
+	s, err := replyString(conn.Do("HGET", a.layoutsKey(), index))
+	if err != nil && err != errNil {
+		return l, err
+	}
+
+	if err == nil {
+		l, err = parseLayout(s)
+		if err != nil {
+			return l, err
+		}
+	}
+
+	if l.docKeys == 0 {
+		l.docKeys = a.keySchema
+	}
+
+	a.layoutsMutex.Lock()
+	a.layouts[index] = l
+	a.layoutsMutex.Unlock()
+
+	return l, nil
+}
+
+// parseLayout parses a stored layout, which is an indexing type optionally
+// followed by '>' and the type it is migrating to, and by '/' and
+// KeySchemaLegacy when the document keys of the index are legacy keys
+func parseLayout(s string) (layout, error) {
+	l := layout{target: notMigrating, loaded: time.Now()}
+
+	if i := strings.Index(s, "/"); i != -1 {
+		var err error
+		if l.docKeys, err = strconv.Atoi(s[i+1:]); err != nil {
+			return l, err
+		}
+
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ">")
+	if len(parts) > 2 {
+		return l, fmt.Errorf("invalid index layout %s", s)
+	}
+
+	t, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return l, err
+	}
+
+	l.indexType = t
+
+	if len(parts) == 2 {
+		if l.target, err = strconv.Atoi(parts[1]); err != nil {
+			return l, err
+		}
+	}
+
+	return l, nil
+}
+
+func (l layout) String() string {
+	s := strconv.Itoa(l.indexType)
+	if l.migrating() {
+		s += ">" + strconv.Itoa(l.target)
+	}
+
+	if l.docKeys == KeySchemaLegacy {
+		s += "/" + strconv.Itoa(KeySchemaLegacy)
+	}
+
+	return s
+}
+
+// Migrate changes the indexing type of an index online, the other layout is
+// built from the stored documents and their scores and then replaces the
+// current layout atomically.
+//
+// while an index is migrating every write maintains both layouts and
+// searches use the current layout, the current layout is deleted once all
+// processes use the new one. an interrupted migration is resumed by calling
+// Migrate again with the same type.
+//
+// processes observe the layout changes by reloading layouts, so Migrate waits
+// for twice the layout TTL after starting the migration and again after
+// replacing the layout, see SetLayoutTTL.
+func (a *Autocomplete) Migrate(index string, toType int) error {
+	if toType != PrefixesIndexing && toType != TermsIndexing {
+		return ErrInvalidIndexType
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	l, err := a.loadLayout(conn, index)
+	if err != nil {
+		return err
+	}
+
+	if l.indexType == toType {
+		return nil
+	}
+
+	if l.migrating() && l.target != toType {
+		return fmt.Errorf("%s is migrating to index type %d", index, l.target)
+	}
+
+	if l.indexType != PrefixesIndexing && l.indexType != TermsIndexing {
+		return ErrInvalidIndexType
+	}
+
+	if !l.migrating() {
+		l.target = toType
+		if err := a.storeLayout(conn, index, l); err != nil {
+			return err
+		}
+
+		time.Sleep(2 * a.layoutTTL)
+	}
+
+	if err := a.copyLayout(conn, index, l); err != nil {
+		return err
+	}
+
+	from := l.indexType
+	l = layout{indexType: toType, target: notMigrating, docKeys: l.docKeys}
+	if err := a.storeLayout(conn, index, l); err != nil {
+		return err
+	}
+
+	time.Sleep(2 * a.layoutTTL)
+
+	return a.deleteLayout(conn, index, from)
+}
+
+func (a *Autocomplete) storeLayout(conn *redisConn, index string,
+	l layout) error {
+
+	if _, err := conn.Do("HSET", a.layoutsKey(), index, l.String()); err != nil {
+		return err
+	}
+
+	l.loaded = time.Now()
+
+	a.layoutsMutex.Lock()
+	a.layouts[index] = l
+	a.layoutsMutex.Unlock()
+
+	return nil
+}
+
+// copyLayout adds every stored document to the target layout with its score
+// in the current layout, documents that were already written to the target
+// layout by writes during the migration are not changed
+func (a *Autocomplete) copyLayout(conn *redisConn, index string,
+	l layout) error {
+
+	return scan(conn, "HSCAN", a.documentsKey(index), "",
+		func(values []string) error {
+			keys := []string{}
+			for i := 0; i < len(values); i += 2 {
+				keys = append(keys, values[i])
+			}
+
+			for len(keys) > 0 {
+				n := migrateBatch
+				if n > len(keys) {
+					n = len(keys)
+				}
+
+				if err := a.copyDocuments(conn, index, l,
+					keys[:n]); err != nil {
+
+					return err
+				}
+
+				keys = keys[n:]
+			}
+
+			return nil
+		})
+}
+
+func (a *Autocomplete) copyDocuments(conn *redisConn, index string, l layout,
+	keys []string) error {
+
+	terms, err := a.primary().storedTerms(index, keys)
+	if err != nil {
+		return err
+	}
+
+	scores, err := a.layoutScores(conn, index, l.indexType, keys, terms)
+	if err != nil {
+		return err
+	}
+
+	for i, docKey := range keys {
+		if scores[i] == nil {
+			// the document is missing from the current layout
+			continue
+		}
+
+		if err := a.sendSecondaryIndex(conn, l.target, index, docKey,
+			terms[i], *scores[i], true); err != nil {
+
+			return err
+		}
+	}
+
+	return receiveAll(conn)
+}
+
+// receiveAll flushes the pipelined commands of a connection and returns the
+// first error reply of their replies, which are received without failing on
+// error replies
+func receiveAll(conn *redisConn) error {
+	return replyError(conn.Do(""))
+}
+
+// replyError returns the error of a command or the first error reply of its
+// multi-bulk reply, such as the replies of the commands of a transaction
+func replyError(reply interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+
+	replies, _ := reply.([]interface{})
+	for _, r := range replies {
+		if err, ok := r.(ReplyError); ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// layoutScores returns the scores of documents in a layout, nil for documents
+// that are missing from it
+func (a *Autocomplete) layoutScores(conn *redisConn, index string,
+	indexType int, keys, terms []string) ([]*uint64, error) {
+
+	scores := make([]*uint64, len(keys))
+
+	switch indexType {
+	case PrefixesIndexing:
+		for i, docKey := range keys {
+			p := a.termPrefixes(terms[i])
+			if len(p) == 0 {
+				p = []string{""}
+			}
+
+			if err := conn.Send("ZSCORE", a.prefixKey(index, p[0]),
+				docKey); err != nil {
+
+				return scores, err
+			}
+		}
+
+		if err := conn.Flush(); err != nil {
+			return scores, err
+		}
+
+		for i := range keys {
+			s, err := replyUint64(conn.Receive())
+			if err == errNil {
+				continue
+			} else if err != nil {
+				return scores, err
+			}
+
+			scores[i] = &s
+		}
+
+	case TermsIndexing:
+		zkey := a.termsIndexKey(index)
+		for i := range keys {
+			min, max := a.termRange(terms[i])
+			if err := conn.Send("ZRANGEBYLEX", zkey, min, max); err != nil {
+
+				return scores, err
+			}
+		}
+
+		if err := conn.Flush(); err != nil {
+			return scores, err
+		}
+
+		members := make([][]string, len(keys))
+		for i := range keys {
+			m, err := replyStrings(conn.Receive())
+			if err != nil {
+				return scores, err
+			}
+
+			members[i] = m
+		}
+
+		for i, docKey := range keys {
+			member := ""
+			for _, m := range members[i] {
+				if strings.HasSuffix(m, a.memberSuffix(docKey)) {
+					member = m
+					break
+				}
+			}
+
+			if member == "" {
+				// the stored term is missing or the member is
+				// missing from the layout
+				var err error
+				member, err = a.termsMember(conn, TermsIndexing, index, docKey)
+				if err != nil {
+					continue
+				}
+			}
+
+			_, s, _, err := a.decodeMember(member)
+			if err != nil {
+				return scores, err
+			}
+
+			scores[i] = &s
+		}
+
+	default:
+		return scores, ErrInvalidIndexType
+	}
+
+	return scores, nil
+}
+
+// sendSecondaryIndex queues the commands that add a document to a layout that
+// is not the current layout of the index, the document is only added if it
+// is stored and if nx is true it is only added if it is not a member already
+func (a *Autocomplete) sendSecondaryIndex(conn *redisConn, indexType int,
+	index, docKey, term string, score uint64, nx bool) error {
+
+	switch indexType {
+	case PrefixesIndexing:
+		script, err := a.script("addIfStored")
+		if err != nil {
+			return err
+		}
+
+		args := []interface{}{a.documentsKey(index)}
+		for _, p := range a.termPrefixes(term) {
+			args = append(args, a.prefixKey(index, p))
+		}
+
+		args = append([]interface{}{len(args)}, args...)
+		args = append(args, docKey, score, nx)
+
+		return script.Send(conn, args...)
+
+	case TermsIndexing:
+		script, err := a.script("setTermsMember")
+		if err != nil {
+			return err
+		}
+
+		val, err := a.encodeMember(term, score, docKey)
+		if err != nil {
+			return err
+		}
+
+		mode := "set"
+		if nx {
+			mode = "nx"
+		}
+
+		min, max := a.termRange(term)
+		return script.Send(conn, a.termsIndexKey(index), a.documentsKey(index),
+			docKey, a.memberSuffix(docKey), min, max, val, mode)
+
+	default:
+		return ErrInvalidIndexType
+	}
+}
+
+// sendSecondaryRemove queues the commands that remove a document from a
+// layout that is not the current layout of the index
+func (a *Autocomplete) sendSecondaryRemove(conn *redisConn, indexType int,
+	index, docKey, term string) error {
+
+	switch indexType {
+	case PrefixesIndexing:
+		for _, p := range a.termPrefixes(term) {
+			if err := conn.Send(
+				"ZREM", a.prefixKey(index, p), docKey); err != nil {
+
+				return err
+			}
+		}
+
+		return nil
+
+	case TermsIndexing:
+		script, err := a.script("setTermsMember")
+		if err != nil {
+			return err
+		}
+
+		min, max := a.termRange(term)
+		return script.Send(conn, a.termsIndexKey(index), a.documentsKey(index),
+			docKey, a.memberSuffix(docKey), min, max, "", "rm")
+
+	default:
+		return ErrInvalidIndexType
+	}
+}
+
+// deleteLayout deletes the ZSETs of a layout of an index
+func (a *Autocomplete) deleteLayout(conn *redisConn, index string,
+	indexType int) error {
+
+	switch indexType {
+	case PrefixesIndexing:
+		return scanKeys(conn, a.prefixKey(index, ""), func(keys []string) error {
+			if len(keys) == 0 {
+				return nil
+			}
+
+			args := []interface{}{}
+			for _, k := range keys {
+				args = append(args, k)
+			}
+
+			_, err := conn.Do("DEL", args...)
+			return err
+		})
+
+	case TermsIndexing:
+		_, err := conn.Do("DEL", a.termsIndexKey(index))
+		return err
+
+	default:
+		return ErrInvalidIndexType
+	}
+}
@@ -0,0 +1,59 @@
+package autocomplete
+
+import (
+	"testing"
+)
+
+func TestParseLayout(t *testing.T) {
+	for _, s := range []string{"0", "1", "0>1", "1>0", "0/1", "0>1/1"} {
+		l, err := parseLayout(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if l.String() != s {
+			t.Errorf("expected %s, got %s", s, l.String())
+		}
+	}
+
+	l, err := parseLayout("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.indexType != TermsIndexing || l.migrating() {
+		t.Errorf("unexpected layout %+v", l)
+	}
+
+	l, err = parseLayout("0>1/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l.docKeys != KeySchemaLegacy || l.target != TermsIndexing {
+		t.Errorf("unexpected layout %+v", l)
+	}
+
+	for _, s := range []string{"", "a", "0>1>0", "0>b", "0/a"} {
+		if _, err := parseLayout(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestReplyError(t *testing.T) {
+	failed := ReplyError("WRONGTYPE Operation against a key")
+
+	err := replyError([]interface{}{int64(1), failed, "OK"}, nil)
+	if err != failed {
+		t.Fatalf("expected the error reply, got %v", err)
+	}
+
+	if err := replyError([]interface{}{int64(1), "OK"}, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := replyError(nil, errNil); err != errNil {
+		t.Fatalf("expected the command error, got %v", err)
+	}
+}
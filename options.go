@@ -0,0 +1,276 @@
+package autocomplete
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultBatchSize is the number of documents fetched by every HMGET
+const defaultBatchSize = 1000
+
+// Option configures the service created by New
+type Option func(a *Autocomplete) error
+
+// Analyzer splits a document term or a search query to its words, the words
+// of terms are indexed by their prefixes and matched by the words of
+// queries. an index must be searched with the analyzer it was indexed with.
+//
+// terms indexing matches entire terms, the analyzer only applies to its
+// relevance ranking and phrase matching.
+type Analyzer func(text string) []string
+
+// DefaultAnalyzer splits text by spaces to its lower case words
+func DefaultAnalyzer(text string) []string {
+	return queryTerms(text)
+}
+
+// analyze splits a term or a query to its words
+func (a *Autocomplete) analyze(text string) []string {
+	return a.analyzer(text)
+}
+
+// Logger logs the errors of the service that are not returned to the caller,
+// *log.Logger is a Logger
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+func (a *Autocomplete) logf(format string, v ...interface{}) {
+	if a.logger != nil {
+		a.logger.Printf(format, v...)
+	}
+}
+
+// unwatch unwatches the keys watched on a connection for an abandoned
+// transaction, an error unwatching is only logged
+func (a *Autocomplete) unwatch(conn *redisConn) {
+	if _, err := conn.Do("UNWATCH"); err != nil {
+		a.logf("autocomplete: UNWATCH failed: %v", err)
+	}
+}
+
+// Metrics receives measurements of the searches of the service, it must be
+// safe for concurrent use
+type Metrics interface {
+	// ObserveSearch is called after every search with its duration, its
+	// number of results, whether it was served from the result cache and
+	// its error
+	ObserveSearch(index string, duration time.Duration, results int,
+		cached bool, err error)
+}
+
+// WithAnalyzer sets the analyzer of terms and queries, the default is
+// DefaultAnalyzer
+func WithAnalyzer(analyzer Analyzer) Option {
+	return func(a *Autocomplete) error {
+		if analyzer == nil {
+			return fmt.Errorf("nil analyzer")
+		}
+
+		a.analyzer = analyzer
+		return nil
+	}
+}
+
+// WithKeySchema sets the key schema version, see SetKeySchema
+func WithKeySchema(version int) Option {
+	return func(a *Autocomplete) error {
+		return a.SetKeySchema(version)
+	}
+}
+
+// WithBatchSize sets the number of documents fetched by every HMGET of a
+// search, the default is 1000
+func WithBatchSize(size int) Option {
+	return func(a *Autocomplete) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid batch size %d", size)
+		}
+
+		a.batchSize = size
+		return nil
+	}
+}
+
+// WithFetchConcurrency sets the maximum number of concurrent HMGET batches of
+// a search, the default of 0 fetches all of the batches concurrently
+func WithFetchConcurrency(n int) Option {
+	return func(a *Autocomplete) error {
+		if n < 0 {
+			return fmt.Errorf("invalid fetch concurrency %d", n)
+		}
+
+		a.fetchConcurrency = n
+		return nil
+	}
+}
+
+// WithCache enables the search result cache, see SetCache
+func WithCache(size int, ttl time.Duration) Option {
+	return func(a *Autocomplete) error {
+		if ttl <= 0 {
+			return fmt.Errorf("invalid cache TTL %s", ttl)
+		}
+
+		a.SetCache(size, ttl)
+		return nil
+	}
+}
+
+// WithLogger sets the logger of errors that are not returned to the caller,
+// they are not logged by default
+func WithLogger(logger Logger) Option {
+	return func(a *Autocomplete) error {
+		a.logger = logger
+		return nil
+	}
+}
+
+// WithMetrics sets the receiver of measurements of searches
+func WithMetrics(metrics Metrics) Option {
+	return func(a *Autocomplete) error {
+		a.metrics = metrics
+		return nil
+	}
+}
+
+// WithReadSource sets the connection source of reads, see SetReadSource
+func WithReadSource(reads ConnSource) Option {
+	return func(a *Autocomplete) error {
+		if reads == nil {
+			return fmt.Errorf("nil read source")
+		}
+
+		a.SetReadSource(reads)
+		return nil
+	}
+}
+
+// WithHashTags enables hash tagging the keys of every index, see SetHashTags
+func WithHashTags() Option {
+	return func(a *Autocomplete) error {
+		a.SetHashTags(true)
+		return nil
+	}
+}
+
+// WithPhonetic enables maintaining the phonetic index, see SetPhonetic
+func WithPhonetic() Option {
+	return func(a *Autocomplete) error {
+		a.SetPhonetic(true)
+		return nil
+	}
+}
+
+// WithCacheInvalidation enables publishing the indexes the service writes to,
+// see SetCacheInvalidation
+func WithCacheInvalidation() Option {
+	return func(a *Autocomplete) error {
+		a.SetCacheInvalidation(true)
+		return nil
+	}
+}
+
+// WithDecay sets the half life of the scores of an index, see SetDecay
+func WithDecay(index string, halfLife time.Duration) Option {
+	return func(a *Autocomplete) error {
+		if halfLife <= 0 {
+			return fmt.Errorf("invalid half life %s", halfLife)
+		}
+
+		a.SetDecay(index, halfLife)
+		return nil
+	}
+}
+
+// WithIntersectionTTL sets the time the intersections of multiple word
+// searches are stored, see SetIntersectionTTL
+func WithIntersectionTTL(ttl time.Duration) Option {
+	return func(a *Autocomplete) error {
+		if ttl <= 0 {
+			return fmt.Errorf("invalid intersection TTL %s", ttl)
+		}
+
+		a.SetIntersectionTTL(ttl)
+		return nil
+	}
+}
+
+// WithLayoutTTL sets the time the layout of an index is cached in process,
+// see SetLayoutTTL
+func WithLayoutTTL(ttl time.Duration) Option {
+	return func(a *Autocomplete) error {
+		if ttl <= 0 {
+			return fmt.Errorf("invalid layout TTL %s", ttl)
+		}
+
+		a.SetLayoutTTL(ttl)
+		return nil
+	}
+}
+
+// WithPersonalization sets the configuration of per user recent selections,
+// see SetPersonalization
+func WithPersonalization(p Personalization) Option {
+	return func(a *Autocomplete) error {
+		if p.HistoryLength <= 0 {
+			return fmt.Errorf("invalid history length %d", p.HistoryLength)
+		}
+
+		a.SetPersonalization(p)
+		return nil
+	}
+}
+
+// WithQueryLog enables logging of executed search queries, see SetQueryLog
+func WithQueryLog(retention time.Duration) Option {
+	return func(a *Autocomplete) error {
+		if retention <= 0 {
+			return fmt.Errorf("invalid query log retention %s", retention)
+		}
+
+		a.SetQueryLog(retention)
+		return nil
+	}
+}
+
+// WithQueryLogSize sets the number of distinct queries kept for query
+// completion, see SetQueryLogSize
+func WithQueryLogSize(size int) Option {
+	return func(a *Autocomplete) error {
+		if size < 0 {
+			return fmt.Errorf("invalid query log size %d", size)
+		}
+
+		a.SetQueryLogSize(size)
+		return nil
+	}
+}
+
+// WithRelevanceWeights sets the weights of SortRelevance searches, see
+// SetRelevanceWeights
+func WithRelevanceWeights(w RelevanceWeights) Option {
+	return func(a *Autocomplete) error {
+		a.SetRelevanceWeights(w)
+		return nil
+	}
+}
+
+// WithClickTracking enables tracking of the documents selected for every
+// query, see SetClickTracking
+func WithClickTracking() Option {
+	return func(a *Autocomplete) error {
+		a.SetClickTracking(true)
+		return nil
+	}
+}
+
+// WithServerSideSearch enables server side searches, see
+// SetServerSideSearch. the search script is loaded when it is first run, or
+// by LoadScripts.
+func WithServerSideSearch() Option {
+	return func(a *Autocomplete) error {
+		a.serverSide = true
+		return nil
+	}
+}
@@ -0,0 +1,160 @@
+package autocomplete
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	invalid := []Option{
+		WithAnalyzer(nil),
+		WithKeySchema(0),
+		WithBatchSize(0),
+		WithFetchConcurrency(-1),
+		WithCache(10, 0),
+		WithReadSource(nil),
+		WithDecay("i", 0),
+		WithIntersectionTTL(0),
+		WithLayoutTTL(0),
+		WithPersonalization(Personalization{}),
+		WithQueryLog(0),
+		WithQueryLogSize(-1),
+	}
+
+	for i, opt := range invalid {
+		if _, err := New(&fakeSource{}, "ac", PrefixesIndexing, opt); err == nil {
+			t.Fatalf("expected an error for option %d", i)
+		}
+	}
+
+	reads := &fakeSource{name: "r"}
+	a, err := New(&fakeSource{}, "ac", TermsIndexing, WithBatchSize(10),
+		WithFetchConcurrency(2), WithReadSource(reads),
+		WithCache(10, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.batchSize != 10 || a.fetchConcurrency != 2 || a.reads != reads ||
+		a.cache == nil {
+
+		t.Fatal("options were not applied")
+	}
+
+	// every setter has an option
+	weights := RelevanceWeights{Score: 1}
+	a, err = New(&fakeSource{}, "ac", PrefixesIndexing, WithHashTags(),
+		WithPhonetic(), WithCacheInvalidation(), WithDecay("i", time.Hour),
+		WithIntersectionTTL(time.Second), WithLayoutTTL(time.Minute),
+		WithPersonalization(Personalization{HistoryLength: 5}),
+		WithQueryLog(time.Hour), WithQueryLogSize(5),
+		WithRelevanceWeights(weights), WithClickTracking(),
+		WithServerSideSearch())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.hashTags || !a.phonetic || !a.publishInvalidations ||
+		a.halfLives["i"] != time.Hour || a.intersectionTTL != time.Second ||
+		a.layoutTTL != time.Minute ||
+		a.personalization.HistoryLength != 5 ||
+		a.queryRetention != time.Hour || a.queryLogSize != 5 ||
+		a.weights != weights || !a.clicks || !a.serverSide {
+
+		t.Fatal("options were not applied")
+	}
+}
+
+func TestWithAnalyzer(t *testing.T) {
+	a, err := New(&fakeSource{}, "ac", PrefixesIndexing,
+		WithAnalyzer(func(text string) []string {
+			return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+				return r == '-' || r == ' '
+			})
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a.termPrefixes("Jean-Luc"),
+		[]string{"j", "je", "jea", "jean", "l", "lu", "luc"}) {
+
+		t.Fatalf("unexpected prefixes %v", a.termPrefixes("Jean-Luc"))
+	}
+}
+
+func TestHmgetBatches(t *testing.T) {
+	var mutex sync.Mutex
+	var batches []int
+	var active, max int
+
+	// replies to HMGET with the requested fields and records the size of
+	// every batch and the highest number of concurrent batches
+	source := &fakeSource{handle: func(cmd string,
+		args []interface{}) (interface{}, error) {
+
+		mutex.Lock()
+		batches = append(batches, len(args)-1)
+		active++
+		if active > max {
+			max = active
+		}
+		mutex.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+
+		values := []interface{}{}
+		for _, a := range args[1:] {
+			values = append(values, []byte(a.(string)))
+		}
+
+		return values, nil
+	}}
+
+	a, err := New(source, "ac", PrefixesIndexing, WithBatchSize(3),
+		WithFetchConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, strconv.Itoa(i))
+	}
+
+	values, err := a.hmget("hash", keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := replyStrings(values, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(results, keys) {
+		t.Fatalf("unexpected values %v", results)
+	}
+
+	if len(batches) != 4 {
+		t.Fatalf("expected 4 batches, got %v", batches)
+	}
+
+	for _, n := range batches {
+		if n > 3 {
+			t.Fatalf("batch of %d keys exceeds the batch size", n)
+		}
+	}
+
+	if max > 2 {
+		t.Fatalf("%d concurrent batches exceed the concurrency", max)
+	}
+}
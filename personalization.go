@@ -0,0 +1,160 @@
+package autocomplete
+
+import (
+	"time"
+)
+
+// Personalization configures the per user recent selections that are boosted
+// in searches for that user
+type Personalization struct {
+	// HistoryLength is the number of most recent selections kept per user
+	HistoryLength int
+
+	// TTL is the time a user's recent selections are kept after the user's
+	// last selection
+	TTL time.Duration
+
+	// Boost is added to the relevance of the user's most recent selection in
+	// SortRelevance searches, less recent selections get a proportionally
+	// smaller boost. in other sort orders any positive boost moves the
+	// user's recent selections to the front of the results.
+	Boost float64
+}
+
+// DefaultPersonalization keeps the last 20 selections of a user for 30 days
+var DefaultPersonalization = Personalization{
+	HistoryLength: 20,
+	TTL:           30 * 24 * time.Hour,
+	Boost:         2,
+}
+
+// SetPersonalization sets the configuration of per user recent selections
+func (a *Autocomplete) SetPersonalization(p Personalization) {
+	a.personalization = p
+}
+
+// recentKey is the key of the ZSET of a user's recent selections, scored by
+// their unix time
+func (a *Autocomplete) recentKey(index, user string) string {
+	return a.indexKey(recentKind, index, user)
+}
+
+// RecordUserSelection records that a user selected a document, the document
+// is boosted in searches with SearchOptions.User set to that user
+func (a *Autocomplete) RecordUserSelection(index, user string,
+	d Document) error {
+
+	l, err := a.layout(index)
+	if err != nil {
+		return err
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	rkey := a.recentKey(index, user)
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+
+	if err := conn.Send("ZADD", rkey, time.Now().Unix(),
+		l.docKey(d)); err != nil {
+
+		return err
+	}
+
+	if err := conn.Send("ZREMRANGEBYRANK", rkey, 0,
+		-a.personalization.HistoryLength-1); err != nil {
+
+		return err
+	}
+
+	if a.personalization.TTL > 0 {
+		if err := conn.Send("EXPIRE", rkey,
+			int64(a.personalization.TTL/time.Second)); err != nil {
+
+			return err
+		}
+	}
+
+	if err := replyError(conn.Do("EXEC")); err != nil {
+		return err
+	}
+
+	return a.invalidateResults(conn, index)
+}
+
+// RecentSelections returns the documents recently selected by a user, most
+// recent first. documents that were removed from the index are omitted.
+func (a *Autocomplete) RecentSelections(index, user string) ([][]byte, error) {
+	keys, err := a.recentSelections(index, user)
+	if err != nil {
+		return [][]byte{}, err
+	}
+
+	values, err := a.hmget(a.documentsKey(index), keys)
+	if err != nil {
+		return [][]byte{}, err
+	}
+
+	results := [][]byte{}
+	for _, v := range values {
+		if b, ok := v.([]byte); ok {
+			results = append(results, b)
+		}
+	}
+
+	return results, nil
+}
+
+// ClearRecentSelections removes all recent selections of a user
+func (a *Autocomplete) ClearRecentSelections(index, user string) error {
+	conn := a.conn()
+	defer conn.Close()
+
+	if _, err := conn.Do("DEL", a.recentKey(index, user)); err != nil {
+		return err
+	}
+
+	return a.invalidateResults(conn, index)
+}
+
+func (a *Autocomplete) recentSelections(index, user string) ([]string, error) {
+	conn := a.readConn()
+	defer conn.Close()
+
+	return replyStrings(conn.Do("ZREVRANGE", a.recentKey(index, user), 0,
+		a.personalization.HistoryLength-1))
+}
+
+// affinities returns the affinity of a user to the given document keys, 1 for
+// the user's most recent selection decreasing linearly by recency to 0 for
+// documents that are not recent selections of the user
+func (a *Autocomplete) affinities(index, user string,
+	keys []string) ([]float64, error) {
+
+	recent, err := a.recentSelections(index, user)
+	if err != nil {
+		return []float64{}, err
+	}
+
+	ranks := make(map[string]int)
+	for i, k := range recent {
+		ranks[k] = i
+	}
+
+	affinities := []float64{}
+	for _, k := range keys {
+		rank, ok := ranks[k]
+		if !ok {
+			affinities = append(affinities, 0)
+			continue
+		}
+
+		affinities = append(affinities,
+			1-float64(rank)/float64(len(recent)))
+	}
+
+	return affinities, nil
+}
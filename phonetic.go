@@ -0,0 +1,225 @@
+package autocomplete
+
+import (
+	"bytes"
+	"strings"
+)
+
+// phoneticKeys returns the keys of the phonetic ZSETs a document with the
+// given term is a member of, one for every prefix of the metaphone code of
+// each of its words
+func (a *Autocomplete) phoneticKeys(index, term string) []string {
+	keys := []string{}
+	for _, c := range phoneticPrefixes(a.analyze(term)) {
+		keys = appendUnique(keys, a.phoneticKey(index, c))
+	}
+
+	return keys
+}
+
+func (a *Autocomplete) phoneticKey(index, code string) string {
+	return a.indexKey(phoneticKind, index, code)
+}
+
+func phoneticPrefixes(words []string) []string {
+	p := []string{}
+
+	for _, w := range words {
+		code := metaphone(w)
+		for i := 1; i <= len(code); i++ {
+			p = appendUnique(p, code[:i])
+		}
+	}
+
+	return p
+}
+
+// metaphone returns the metaphone code of a single word, non ASCII letters
+// are ignored.
+//
+// it is an implementation of the original algorithm by Lawrence Philips,
+// codes are upper case and '0' stands for the 'th' sound.
+func metaphone(word string) string {
+	w := []byte{}
+	for _, c := range []byte(strings.ToUpper(word)) {
+		if c >= 'A' && c <= 'Z' {
+			w = append(w, c)
+		}
+	}
+
+	if len(w) == 0 {
+		return ""
+	}
+
+	at := func(i int) byte {
+		if i < 0 || i >= len(w) {
+			return 0
+		}
+
+		return w[i]
+	}
+
+	isVowel := func(c byte) bool {
+		return c == 'A' || c == 'E' || c == 'I' || c == 'O' || c == 'U'
+	}
+
+	isFrontVowel := func(c byte) bool {
+		return c == 'E' || c == 'I' || c == 'Y'
+	}
+
+	// initial letter exceptions
+	switch {
+	case len(w) > 1 && (string(w[:2]) == "AE" || string(w[:2]) == "GN" ||
+		string(w[:2]) == "KN" || string(w[:2]) == "PN" || string(w[:2]) == "WR"):
+
+		w = w[1:]
+
+	case w[0] == 'X':
+		w[0] = 'S'
+
+	case len(w) > 1 && string(w[:2]) == "WH":
+		w = append([]byte{'W'}, w[2:]...)
+	}
+
+	buf := new(bytes.Buffer)
+
+	for i := 0; i < len(w); i++ {
+		c := w[i]
+
+		// skip duplicate adjacent letters, except for C
+		if c != 'C' && c == at(i-1) {
+			continue
+		}
+
+		switch c {
+		case 'A', 'E', 'I', 'O', 'U':
+			if i == 0 {
+				buf.WriteByte(c)
+			}
+
+		case 'B':
+			if !(at(i-1) == 'M' && i == len(w)-1) {
+				buf.WriteByte('B')
+			}
+
+		case 'C':
+			switch {
+			case at(i+1) == 'I' && at(i+2) == 'A':
+				buf.WriteByte('X')
+
+			case at(i+1) == 'H':
+				if at(i-1) == 'S' {
+					buf.WriteByte('K')
+				} else {
+					buf.WriteByte('X')
+				}
+				i++
+
+			case isFrontVowel(at(i + 1)):
+				if at(i-1) != 'S' {
+					buf.WriteByte('S')
+				}
+
+			default:
+				buf.WriteByte('K')
+			}
+
+		case 'D':
+			if at(i+1) == 'G' && isFrontVowel(at(i+2)) {
+				buf.WriteByte('J')
+				i++
+			} else {
+				buf.WriteByte('T')
+			}
+
+		case 'G':
+			switch {
+			case at(i+1) == 'H' && i+2 < len(w) && !isVowel(at(i+2)):
+				// silent as in "night"
+
+			case at(i+1) == 'N' && (i+2 == len(w) ||
+				(at(i+2) == 'E' && at(i+3) == 'D' && i+4 == len(w))):
+				// silent as in "sign" and "signed"
+
+			case isFrontVowel(at(i+1)) && at(i-1) != 'G':
+				buf.WriteByte('J')
+
+			default:
+				buf.WriteByte('K')
+			}
+
+		case 'H':
+			prev := at(i - 1)
+			if prev == 'C' || prev == 'G' || prev == 'P' || prev == 'S' ||
+				prev == 'T' {
+
+				continue
+			}
+
+			if isVowel(prev) && !isVowel(at(i+1)) {
+				continue
+			}
+
+			buf.WriteByte('H')
+
+		case 'K':
+			if at(i-1) != 'C' {
+				buf.WriteByte('K')
+			}
+
+		case 'P':
+			if at(i+1) == 'H' {
+				buf.WriteByte('F')
+			} else {
+				buf.WriteByte('P')
+			}
+
+		case 'Q':
+			buf.WriteByte('K')
+
+		case 'S':
+			if at(i+1) == 'H' || (at(i+1) == 'I' &&
+				(at(i+2) == 'O' || at(i+2) == 'A')) {
+
+				buf.WriteByte('X')
+			} else {
+				buf.WriteByte('S')
+			}
+
+		case 'T':
+			switch {
+			case at(i+1) == 'I' && (at(i+2) == 'O' || at(i+2) == 'A'):
+				buf.WriteByte('X')
+
+			case at(i+1) == 'H':
+				buf.WriteByte('0')
+
+			case at(i+1) == 'C' && at(i+2) == 'H':
+				// silent as in "watch"
+
+			default:
+				buf.WriteByte('T')
+			}
+
+		case 'V':
+			buf.WriteByte('F')
+
+		case 'W', 'Y':
+			if isVowel(at(i + 1)) {
+				buf.WriteByte(c)
+			}
+
+		case 'X':
+			buf.WriteString("KS")
+
+		case 'Z':
+			buf.WriteByte('S')
+
+		default:
+			// F, J, L, M, N, R
+			buf.WriteByte(c)
+		}
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,35 @@
+package autocomplete
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMetaphone(t *testing.T) {
+	codes := map[string]string{
+		"Smith":     "SM0",
+		"Smyth":     "SM0",
+		"Katherine": "K0RN",
+		"Catherine": "K0RN",
+		"knight":    "NT",
+		"Xavier":    "SFR",
+		"school":    "SKL",
+		"edge":      "EJ",
+		"S500":      "S",
+		"500":       "",
+	}
+
+	for w, code := range codes {
+		if c := metaphone(w); c != code {
+			t.Errorf("metaphone(%q) = %q, expected %q", w, c, code)
+		}
+	}
+}
+
+func TestPhoneticPrefixes(t *testing.T) {
+	if !reflect.DeepEqual(phoneticPrefixes([]string{"john", "smith"}),
+		[]string{"J", "JN", "S", "SM", "SM0"}) {
+
+		t.Fail()
+	}
+}
@@ -0,0 +1,108 @@
+package autocomplete
+
+import (
+	"strings"
+)
+
+// termsKey is the key of the hash that stores the original term of every
+// indexed document
+func (a *Autocomplete) termsKey(index string) string {
+	return a.indexKey(storedTermsKind, index)
+}
+
+// storedTerms returns the original terms of the given document keys.
+//
+// documents indexed before terms were stored get their term restored from
+// the document key, which only approximates it for legacy keys.
+func (a *Autocomplete) storedTerms(index string, keys []string) ([]string, error) {
+	l, err := a.layout(index)
+	if err != nil {
+		return []string{}, err
+	}
+
+	values, err := a.hmget(a.termsKey(index), keys)
+	if err != nil {
+		return []string{}, err
+	}
+
+	terms := []string{}
+	for i, v := range values {
+		term, err := replyString(v, nil)
+		if err == errNil {
+			term = keyTerm(l.docKeys, keys[i])
+		} else if err != nil {
+			return []string{}, err
+		}
+
+		terms = append(terms, term)
+	}
+
+	return terms, nil
+}
+
+// orderMatches reports for every document key whether its term matches the
+// query words in order, or as a phrase when phrase is true. it returns nil
+// when the query has less than two words.
+func (a *Autocomplete) orderMatches(index, query string, keys []string,
+	phrase bool) ([]bool, error) {
+
+	words := a.analyze(query)
+	if len(words) < 2 || len(keys) == 0 {
+		return nil, nil
+	}
+
+	terms, err := a.storedTerms(index, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []bool{}
+	for i := range keys {
+		if phrase {
+			matches = append(matches, phraseMatch(words, a.analyze(terms[i])))
+		} else {
+			matches = append(matches, inOrderMatch(words, a.analyze(terms[i])))
+		}
+	}
+
+	return matches, nil
+}
+
+// phraseMatch reports whether the query words are prefixes of adjacent words
+// of the term in the same order
+func phraseMatch(query, term []string) bool {
+	for i := 0; i+len(query) <= len(term); i++ {
+		match := true
+		for j, q := range query {
+			if !strings.HasPrefix(term[i+j], q) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inOrderMatch reports whether the query words are prefixes of words of the
+// term in the same order, not necessarily adjacent
+func inOrderMatch(query, term []string) bool {
+	pos := 0
+	for _, q := range query {
+		for pos < len(term) && !strings.HasPrefix(term[pos], q) {
+			pos++
+		}
+
+		if pos == len(term) {
+			return false
+		}
+
+		pos++
+	}
+
+	return true
+}
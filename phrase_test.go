@@ -0,0 +1,35 @@
+package autocomplete
+
+import "testing"
+
+func TestPhraseMatch(t *testing.T) {
+	if !phraseMatch([]string{"new", "yo"}, []string{"new", "york"}) {
+		t.Fail()
+	}
+
+	if !phraseMatch([]string{"new", "yo"}, []string{"the", "new", "york", "times"}) {
+		t.Fail()
+	}
+
+	if phraseMatch([]string{"new", "yo"}, []string{"york", "new", "station"}) {
+		t.Fail()
+	}
+
+	if phraseMatch([]string{"new", "yo"}, []string{"new", "big", "york"}) {
+		t.Fail()
+	}
+}
+
+func TestInOrderMatch(t *testing.T) {
+	if !inOrderMatch([]string{"new", "yo"}, []string{"new", "big", "york"}) {
+		t.Fail()
+	}
+
+	if inOrderMatch([]string{"new", "yo"}, []string{"york", "new", "station"}) {
+		t.Fail()
+	}
+
+	if inOrderMatch([]string{"new", "new"}, []string{"new", "york"}) {
+		t.Fail()
+	}
+}
@@ -0,0 +1,168 @@
+package autocomplete
+
+import (
+	"strconv"
+	"time"
+)
+
+// queryBucket is the time span of every bucket of the query log
+const queryBucket = time.Hour
+
+// DefaultQueryLogSize is the default number of distinct queries kept for
+// query completion
+const DefaultQueryLogSize = 10000
+
+// SetQueryLog enables logging of executed search queries, the queries are
+// counted in hourly buckets that are kept for the given retention. a zero
+// retention disables the query log.
+//
+// the queries completed by SearchQueries are limited to the most executed
+// queries, see SetQueryLogSize, and are dropped when no query was logged for
+// the retention.
+func (a *Autocomplete) SetQueryLog(retention time.Duration) {
+	a.queryRetention = retention
+}
+
+// SetQueryLogSize sets the number of distinct queries kept for query
+// completion, the least executed queries are dropped when it is exceeded. the
+// default is DefaultQueryLogSize and a size of 0 keeps all of the queries.
+func (a *Autocomplete) SetQueryLogSize(size int) {
+	a.queryLogSize = size
+}
+
+// queryBucketKey is the key of the ZSET that counts the queries executed in
+// the bucket of the given time
+func (a *Autocomplete) queryBucketKey(index string, t time.Time) string {
+	bucket := t.Truncate(queryBucket).Unix()
+	return a.indexKey(queryBucketKind, index, strconv.FormatInt(bucket, 10))
+}
+
+// queriesKey is the key of the ZSET of all logged queries for lexicographical
+// completion
+func (a *Autocomplete) queriesKey(index string) string {
+	return a.indexKey(queriesKind, index)
+}
+
+// queryCountsKey is the key of the ZSET that counts all logged queries
+func (a *Autocomplete) queryCountsKey(index string) string {
+	return a.indexKey(queryCountsKind, index)
+}
+
+// RecordQuery logs an executed search query, searches log their queries when
+// the query log is enabled
+func (a *Autocomplete) RecordQuery(index, query string) error {
+	q := a.normalizedQuery(query)
+	if q == "" {
+		return nil
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	now := time.Now()
+	expireAt := now.Truncate(queryBucket).Add(queryBucket + a.queryRetention)
+
+	_, err := a.runScript(conn, "recordQuery", a.queryBucketKey(index, now),
+		a.queriesKey(index), a.queryCountsKey(index), q, expireAt.Unix(),
+		a.queryLogSize, int64(a.queryRetention/time.Millisecond))
+
+	return err
+}
+
+// TopQueries returns the n most executed queries in the given time window up
+// to now, most executed first. the window is rounded up to whole hours.
+func (a *Autocomplete) TopQueries(index string, window time.Duration,
+	n int) ([]string, error) {
+
+	if n <= 0 {
+		return []string{}, nil
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	now := time.Now()
+	keys := []interface{}{}
+	for t := now; now.Sub(t) < window || len(keys) == 0; t = t.Add(-queryBucket) {
+		keys = append(keys, a.queryBucketKey(index, t))
+	}
+
+	tkey := a.indexKey(queryTempKind, index,
+		strconv.FormatInt(now.UnixNano(), 10))
+
+	args := append([]interface{}{tkey, len(keys)}, keys...)
+	if err := conn.Send("MULTI"); err != nil {
+		return []string{}, err
+	}
+
+	if err := conn.Send("ZUNIONSTORE", args...); err != nil {
+		return []string{}, err
+	}
+
+	if err := conn.Send("ZREVRANGE", tkey, 0, n-1); err != nil {
+		return []string{}, err
+	}
+
+	if err := conn.Send("DEL", tkey); err != nil {
+		return []string{}, err
+	}
+
+	reply, err := conn.Do("EXEC")
+	if err := replyError(reply, err); err != nil {
+		return []string{}, err
+	}
+
+	values, err := replyValues(reply, nil)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return replyStrings(values[1], nil)
+}
+
+// SearchQueries returns the n most executed logged queries that start with
+// the given prefix, most executed first
+func (a *Autocomplete) SearchQueries(index, prefix string,
+	n int) ([]string, error) {
+
+	if n <= 0 {
+		return []string{}, nil
+	}
+
+	conn := a.readConn()
+	defer conn.Close()
+
+	p := a.normalizedQuery(prefix)
+	queries, err := replyStrings(conn.Do("ZRANGEBYLEX", a.queriesKey(index),
+		"["+p, "["+p+"\xff"))
+	if err != nil {
+		return []string{}, err
+	}
+
+	for _, q := range queries {
+		if err := conn.Send("ZSCORE", a.queryCountsKey(index), q); err != nil {
+			return []string{}, err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return []string{}, err
+	}
+
+	counts := []float64{}
+	for range queries {
+		c, err := replyFloat64(conn.Receive())
+		if err != nil {
+			return []string{}, err
+		}
+
+		counts = append(counts, c)
+	}
+
+	results := sortKeys(queries, counts, true)
+	if len(results) > n {
+		results = results[:n]
+	}
+
+	return results, nil
+}
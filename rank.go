@@ -0,0 +1,226 @@
+package autocomplete
+
+import (
+	"sort"
+)
+
+// rankedKey is a document key of the results of a search with the signals
+// its result is ranked by, in order of precedence: literal matches come
+// before phonetic matches, then results are ordered by the affinity of the
+// user, by the clicks for the query, by matching the query words in order and
+// by their relevance or decayed score
+type rankedKey struct {
+	key      string
+	phonetic bool
+	affinity float64
+	clicks   float64
+	inOrder  bool
+	value    float64
+}
+
+// rankKeys ranks the document keys that match a query literally, scores are
+// the decayed scores of the keys, or nil when the results are not ordered by
+// them, and relevance is normalized by max. phonetic matches are added after
+// the literal matches and expired documents are removed.
+func (a *Autocomplete) rankKeys(index, query string, opts SearchOptions,
+	keys []string, scores []float64, max float64) ([]rankedKey, error) {
+
+	l, err := a.layout(index)
+	if err != nil {
+		return []rankedKey{}, err
+	}
+
+	ranked := make([]rankedKey, len(keys))
+	for i, k := range keys {
+		ranked[i].key = k
+		if scores != nil {
+			ranked[i].value = scores[i]
+		}
+	}
+
+	if opts.Sort == SortRelevance && len(keys) > 0 {
+		relevance, err := a.relevances(index, query, opts.User, keys, scores,
+			max)
+		if err != nil {
+			return []rankedKey{}, err
+		}
+
+		for i := range ranked {
+			ranked[i].value = relevance[i]
+		}
+	}
+
+	if l.indexType == PrefixesIndexing && (opts.Phrase || opts.InOrderBoost) {
+		matches, err := a.orderMatches(index, query, keys, opts.Phrase)
+		if err != nil {
+			return []rankedKey{}, err
+		}
+
+		if matches != nil {
+			kept := []rankedKey{}
+			for i, r := range ranked {
+				r.inOrder = matches[i]
+				if r.inOrder || !opts.Phrase {
+					kept = append(kept, r)
+				}
+			}
+
+			ranked = kept
+		}
+	}
+
+	if opts.ClickBoost && len(ranked) > 0 {
+		clicks, err := a.clickCounts(index, query, rankedKeys(ranked))
+		if err != nil {
+			return []rankedKey{}, err
+		}
+
+		for i := range ranked {
+			ranked[i].clicks = clicks[i]
+		}
+	}
+
+	if opts.User != "" && opts.Sort != SortRelevance &&
+		a.personalization.Boost > 0 && len(ranked) > 0 {
+
+		affinities, err := a.affinities(index, opts.User, rankedKeys(ranked))
+		if err != nil {
+			return []rankedKey{}, err
+		}
+
+		for i := range ranked {
+			ranked[i].affinity = affinities[i]
+		}
+	}
+
+	if opts.Phonetic {
+		ranked, err = a.addPhoneticMatches(index, query, opts.Sort, ranked,
+			scores != nil)
+		if err != nil {
+			return []rankedKey{}, err
+		}
+	}
+
+	unexpired, err := a.withoutExpired(index, rankedKeys(ranked))
+	if err != nil {
+		return []rankedKey{}, err
+	}
+
+	if len(unexpired) < len(ranked) {
+		kept := make(map[string]bool)
+		for _, k := range unexpired {
+			kept[k] = true
+		}
+
+		results := []rankedKey{}
+		for _, r := range ranked {
+			if kept[r.key] {
+				results = append(results, r)
+			}
+		}
+
+		ranked = results
+	}
+
+	return ranked, nil
+}
+
+// addPhoneticMatches adds the keys that match the query phonetically and are
+// not literal matches, with their decayed scores when scored is true
+func (a *Autocomplete) addPhoneticMatches(index, query string, orderBy int,
+	ranked []rankedKey, scored bool) ([]rankedKey, error) {
+
+	phoneticKeys, err := a.phoneticSearch(index, query, orderBy)
+	if err != nil {
+		return []rankedKey{}, err
+	}
+
+	found := make(map[string]bool)
+	for _, r := range ranked {
+		found[r.key] = true
+	}
+
+	added := []string{}
+	for _, k := range phoneticKeys {
+		if !found[k] {
+			found[k] = true
+			added = append(added, k)
+		}
+	}
+
+	var scores []float64
+	if scored && len(added) > 0 {
+		scores, err = a.decayedScores(index, added)
+		if err != nil {
+			return []rankedKey{}, err
+		}
+	}
+
+	for i, k := range added {
+		r := rankedKey{key: k, phonetic: true}
+		if scores != nil {
+			r.value = scores[i]
+		}
+
+		ranked = append(ranked, r)
+	}
+
+	return ranked, nil
+}
+
+// rankedKeys returns the document keys of ranked keys
+func rankedKeys(ranked []rankedKey) []string {
+	keys := []string{}
+	for _, r := range ranked {
+		keys = append(keys, r.key)
+	}
+
+	return keys
+}
+
+// sortRanked stable sorts ranked document keys in the order of their results.
+// lexicographical sorts keep the order of keys with the same signals, unless
+// byKey is true and they are ordered by their document keys.
+func sortRanked(ranked []rankedKey, orderBy int, byKey bool) {
+	sort.Stable(byRank{ranked: ranked, orderBy: orderBy, byKey: byKey})
+}
+
+type byRank struct {
+	ranked  []rankedKey
+	orderBy int
+	byKey   bool
+}
+
+func (r byRank) Len() int {
+	return len(r.ranked)
+}
+
+func (r byRank) Less(i, j int) bool {
+	k1, k2 := r.ranked[i], r.ranked[j]
+
+	switch {
+	case k1.phonetic != k2.phonetic:
+		return !k1.phonetic
+	case k1.affinity != k2.affinity:
+		return k1.affinity > k2.affinity
+	case k1.clicks != k2.clicks:
+		return k1.clicks > k2.clicks
+	case k1.inOrder != k2.inOrder:
+		return k1.inOrder
+	}
+
+	switch r.orderBy {
+	case SortLexicographical:
+		return r.byKey && k1.key < k2.key
+	case SortRevLexicographical:
+		return r.byKey && k1.key > k2.key
+	case SortScore:
+		return k1.value < k2.value
+	default:
+		return k1.value > k2.value
+	}
+}
+
+func (r byRank) Swap(i, j int) {
+	r.ranked[i], r.ranked[j] = r.ranked[j], r.ranked[i]
+}
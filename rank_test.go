@@ -0,0 +1,40 @@
+package autocomplete
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortRanked(t *testing.T) {
+	ranked := func() []rankedKey {
+		return []rankedKey{
+			{key: "e", value: 3},
+			{key: "c", value: 1, phonetic: true},
+			{key: "b", value: 2, inOrder: true},
+			{key: "d", value: 4},
+			{key: "a", value: 1, clicks: 1},
+			{key: "f", value: 5, affinity: 0.5},
+		}
+	}
+
+	tests := []struct {
+		orderBy  int
+		byKey    bool
+		expected []string
+	}{
+		{SortRevScore, false, []string{"f", "a", "b", "d", "e", "c"}},
+		{SortScore, false, []string{"f", "a", "b", "e", "d", "c"}},
+		{SortLexicographical, false, []string{"f", "a", "b", "e", "d", "c"}},
+		{SortLexicographical, true, []string{"f", "a", "b", "d", "e", "c"}},
+		{SortRevLexicographical, true, []string{"f", "a", "b", "e", "d", "c"}},
+	}
+
+	for _, test := range tests {
+		r := ranked()
+		sortRanked(r, test.orderBy, test.byKey)
+
+		if keys := rankedKeys(r); !reflect.DeepEqual(keys, test.expected) {
+			t.Fatalf("expected %v, got %v", test.expected, keys)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package autocomplete
+
+import (
+	"sort"
+	"strings"
+)
+
+// RelevanceWeights are the weights of the signals combined by SortRelevance,
+// every signal is normalized to the range [0, 1] before it is weighted.
+type RelevanceWeights struct {
+	// Score weights the stored score relative to the highest score among
+	// the matched documents
+	Score float64
+
+	// Exact weights a term that is equal to the query
+	Exact float64
+
+	// FirstWord weights a term whose first word starts with the first
+	// query word
+	FirstWord float64
+
+	// CompletedWords weights the ratio of query words that are complete
+	// words of the term
+	CompletedWords float64
+
+	// Length weights the ratio of the query length to the term length, so
+	// shorter terms rank higher
+	Length float64
+}
+
+// DefaultRelevanceWeights rank exact matches above any other match regardless
+// of their stored scores
+var DefaultRelevanceWeights = RelevanceWeights{
+	Score:          1,
+	Exact:          4,
+	FirstWord:      2,
+	CompletedWords: 1,
+	Length:         0.5,
+}
+
+// SetRelevanceWeights sets the weights used for SortRelevance searches
+func (a *Autocomplete) SetRelevanceWeights(w RelevanceWeights) {
+	a.weights = w
+}
+
+// relevance returns the weighted match quality of a query in a term, without
+// the score signal
+func (w RelevanceWeights) relevance(query, term []string) float64 {
+	if len(query) == 0 || len(term) == 0 {
+		return 0
+	}
+
+	r := 0.0
+
+	q := strings.Join(query, " ")
+	t := strings.Join(term, " ")
+
+	if q == t {
+		r += w.Exact
+	}
+
+	if strings.HasPrefix(term[0], query[0]) {
+		r += w.FirstWord
+	}
+
+	completed := 0
+	for _, qw := range query {
+		for _, tw := range term {
+			if qw == tw {
+				completed++
+				break
+			}
+		}
+	}
+
+	r += w.CompletedWords * float64(completed) / float64(len(query))
+
+	if len(q) < len(t) {
+		r += w.Length * float64(len(q)) / float64(len(t))
+	} else {
+		r += w.Length
+	}
+
+	return r
+}
+
+// relevances returns the relevance of every document key given its decayed
+// score, scores are normalized by max
+func (a *Autocomplete) relevances(index, query, user string, keys []string,
+	scores []float64, max float64) ([]float64, error) {
+
+	terms, err := a.storedTerms(index, keys)
+	if err != nil {
+		return []float64{}, err
+	}
+
+	affinities := make([]float64, len(keys))
+	if user != "" {
+		affinities, err = a.affinities(index, user, keys)
+		if err != nil {
+			return []float64{}, err
+		}
+	}
+
+	words := a.analyze(query)
+	relevance := []float64{}
+	for i := range keys {
+		r := a.weights.relevance(words, a.analyze(terms[i]))
+		if max > 0 {
+			r += a.weights.Score * scores[i] / max
+		}
+
+		r += a.personalization.Boost * affinities[i]
+
+		relevance = append(relevance, r)
+	}
+
+	return relevance, nil
+}
+
+func maxScore(scores []float64) float64 {
+	max := 0.0
+	for _, s := range scores {
+		if s > max {
+			max = s
+		}
+	}
+
+	return max
+}
+
+// scores returns the stored scores of the given document keys, they are
+// read from the layout by the stored terms of the documents so keys that were
+// matched by phonetic codes or spelling corrections are scored too. documents
+// missing from the layout score 0.
+func (a *Autocomplete) scores(index string, keys []string) ([]float64, error) {
+	if len(keys) == 0 {
+		return []float64{}, nil
+	}
+
+	l, err := a.layout(index)
+	if err != nil {
+		return []float64{}, err
+	}
+
+	terms, err := a.storedTerms(index, keys)
+	if err != nil {
+		return []float64{}, err
+	}
+
+	conn := a.readConn()
+	defer conn.Close()
+
+	stored, err := a.layoutScores(conn, index, l.indexType, keys, terms)
+	if err != nil {
+		return []float64{}, err
+	}
+
+	scores := []float64{}
+	for _, s := range stored {
+		if s == nil {
+			scores = append(scores, 0)
+			continue
+		}
+
+		scores = append(scores, float64(*s))
+	}
+
+	return scores, nil
+}
+
+// sortKeys stable sorts document keys by the given values
+func sortKeys(keys []string, values []float64, reverse bool) []string {
+	ranked := make(byRelevance, len(keys))
+	for i, k := range keys {
+		ranked[i] = relevantKey{key: k, relevance: values[i]}
+	}
+
+	if reverse {
+		sort.Stable(sort.Reverse(ranked))
+	} else {
+		sort.Stable(ranked)
+	}
+
+	results := []string{}
+	for _, r := range ranked {
+		results = append(results, r.key)
+	}
+
+	return results
+}
+
+type relevantKey struct {
+	key       string
+	relevance float64
+}
+
+type byRelevance []relevantKey
+
+func (v byRelevance) Len() int {
+	return len(v)
+}
+
+func (v byRelevance) Less(i, j int) bool {
+	return v[i].relevance < v[j].relevance
+}
+
+func (v byRelevance) Swap(i, j int) {
+	v[i], v[j] = v[j], v[i]
+}
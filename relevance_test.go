@@ -0,0 +1,41 @@
+package autocomplete
+
+import "testing"
+
+func TestRelevance(t *testing.T) {
+	w := DefaultRelevanceWeights
+
+	exact := w.relevance([]string{"ford"}, []string{"ford"})
+	firstWord := w.relevance([]string{"ford"}, []string{"fordham", "university"})
+	otherWord := w.relevance([]string{"ford"}, []string{"port", "fordham"})
+
+	if exact <= firstWord+w.Score {
+		t.Errorf("exact match %v is not above first word match %v", exact,
+			firstWord)
+	}
+
+	if firstWord <= otherWord {
+		t.Errorf("first word match %v is not above other word match %v",
+			firstWord, otherWord)
+	}
+
+	if w.relevance([]string{}, []string{"ford"}) != 0 {
+		t.Fail()
+	}
+}
+
+func TestParseScoreString(t *testing.T) {
+	s, err := scoreString(1234567890)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	score, err := parseScoreString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if score != 1234567890 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,242 @@
+package autocomplete
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaRetry is the time an unhealthy replica is skipped before it is
+// tried again
+const replicaRetry = 5 * time.Second
+
+// SetReadSource sets the connection source of searches, document fetches and
+// query statistics, writes and reads that must observe the latest writes use
+// the connection source given to New.
+//
+// a read source is usually Replicas of the primary, SetReadSource should be
+// called before the service is used.
+func (a *Autocomplete) SetReadSource(reads ConnSource) {
+	a.reads = reads
+}
+
+// primary returns the service with its reads served by the primary connection
+// source
+func (a *Autocomplete) primary() *Autocomplete {
+	p := *a
+	p.reads = a.pool
+
+	return &p
+}
+
+// searchService returns the service of a single search, its reads are served
+// by a single replica of the read source until the search stores an
+// intersection on the primary, and by the primary after that, so documents
+// are read from the source that returned their keys
+func (a *Autocomplete) searchService() *Autocomplete {
+	reads := a.reads
+	if r, ok := reads.(*Replicas); ok {
+		reads = r.pinned()
+	}
+
+	s := *a
+	s.reads = &searchReads{reads: reads, primary: a.pool}
+
+	return &s
+}
+
+// searchReads is the read source of a single search
+type searchReads struct {
+	reads     ConnSource
+	primary   ConnSource
+	onPrimary int32
+}
+
+func (s *searchReads) Get() Conn {
+	if atomic.LoadInt32(&s.onPrimary) == 1 {
+		return s.primary.Get()
+	}
+
+	return s.reads.Get()
+}
+
+// usePrimary serves the rest of the reads of the search by the primary
+func (s *searchReads) usePrimary() {
+	atomic.StoreInt32(&s.onPrimary, 1)
+}
+
+// Replicas is a connection source that balances connections across read
+// replicas in turn, a replica is skipped for a while when it is unhealthy and
+// connections fall back to the primary when all of the replicas are. a
+// command that fails on an unhealthy replica is retried on the primary.
+//
+// a replica is unhealthy when it can not be connected to, when a command
+// fails with a network error, or when it replies that it is loading its
+// dataset or lost its link to the master.
+type Replicas struct {
+	primary  ConnSource
+	replicas []ConnSource
+	next     uint32
+
+	down      []time.Time
+	downMutex sync.Mutex
+}
+
+// NewReplicas returns a connection source for the given replicas of the
+// primary
+func NewReplicas(primary ConnSource, replicas ...ConnSource) *Replicas {
+	return &Replicas{
+		primary:  primary,
+		replicas: replicas,
+		down:     make([]time.Time, len(replicas)),
+	}
+}
+
+// Get returns a connection to the next healthy replica, or to the primary
+func (r *Replicas) Get() Conn {
+	healthy := r.healthy()
+	if len(healthy) == 0 {
+		return r.primary.Get()
+	}
+
+	start := atomic.AddUint32(&r.next, 1)
+	replica := healthy[start%uint32(len(healthy))]
+
+	return &replicaConn{Conn: r.replicas[replica].Get(), replicas: r,
+		replica: replica}
+}
+
+// pinned returns a connection source of the next healthy replica, or of the
+// primary, which keeps serving the primary once the replica fails
+func (r *Replicas) pinned() ConnSource {
+	healthy := r.healthy()
+	if len(healthy) == 0 {
+		return r.primary
+	}
+
+	start := atomic.AddUint32(&r.next, 1)
+	return &pinnedReplica{replicas: r,
+		replica: healthy[start%uint32(len(healthy))]}
+}
+
+// pinnedReplica is a connection source of a single replica
+type pinnedReplica struct {
+	replicas *Replicas
+	replica  int
+}
+
+func (p *pinnedReplica) Get() Conn {
+	if !p.replicas.isHealthy(p.replica) {
+		return p.replicas.primary.Get()
+	}
+
+	return &replicaConn{Conn: p.replicas.replicas[p.replica].Get(),
+		replicas: p.replicas, replica: p.replica}
+}
+
+// healthy returns the replicas that are not skipped
+func (r *Replicas) healthy() []int {
+	r.downMutex.Lock()
+	defer r.downMutex.Unlock()
+
+	now := time.Now()
+	healthy := []int{}
+	for i, until := range r.down {
+		if now.After(until) {
+			healthy = append(healthy, i)
+		}
+	}
+
+	return healthy
+}
+
+func (r *Replicas) isHealthy(replica int) bool {
+	r.downMutex.Lock()
+	defer r.downMutex.Unlock()
+
+	return time.Now().After(r.down[replica])
+}
+
+func (r *Replicas) setDown(replica int) {
+	r.downMutex.Lock()
+	defer r.downMutex.Unlock()
+
+	r.down[replica] = time.Now().Add(replicaRetry)
+}
+
+// replicaConn is a connection to a replica that marks the replica unhealthy
+// when it fails, the failed command or pipeline is executed again on a
+// connection to the primary, which serves the rest of the connection's
+// commands
+type replicaConn struct {
+	Conn
+	replicas *Replicas
+	replica  int
+
+	fallback Conn
+}
+
+func (c *replicaConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if c.fallback != nil {
+		return c.fallback.Do(cmd, args...)
+	}
+
+	reply, err := c.Conn.Do(cmd, args...)
+	if replicaFailed(err) {
+		c.failover()
+		return c.fallback.Do(cmd, args...)
+	}
+
+	return reply, err
+}
+
+func (c *replicaConn) Pipeline(cmds []Command) ([]interface{}, error) {
+	if c.fallback != nil {
+		return c.fallback.Pipeline(cmds)
+	}
+
+	replies, err := c.Conn.Pipeline(cmds)
+	if replicaFailed(err) {
+		c.failover()
+		return c.fallback.Pipeline(cmds)
+	}
+
+	for _, r := range replies {
+		if e, ok := r.(ReplyError); ok && replicaFailed(e) {
+			c.failover()
+			return c.fallback.Pipeline(cmds)
+		}
+	}
+
+	return replies, err
+}
+
+func (c *replicaConn) Close() error {
+	if c.fallback != nil {
+		c.fallback.Close()
+	}
+
+	return c.Conn.Close()
+}
+
+// failover marks the replica unhealthy and connects to the primary
+func (c *replicaConn) failover() {
+	c.replicas.setDown(c.replica)
+	c.fallback = c.replicas.primary.Get()
+}
+
+// replicaFailed reports whether an error is a failure of a replica
+func replicaFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if e, ok := err.(ReplyError); ok {
+		s := string(e)
+		return strings.HasPrefix(s, "LOADING") ||
+			strings.HasPrefix(s, "MASTERDOWN")
+	}
+
+	return true
+}
@@ -0,0 +1,109 @@
+package autocomplete
+
+import (
+	"errors"
+	"testing"
+)
+
+func connName(conn Conn) string {
+	defer conn.Close()
+
+	reply, _ := conn.Do("PING")
+	return reply.(string)
+}
+
+func TestReplicas(t *testing.T) {
+	unreachable := &fakeSource{err: errors.New("connection refused")}
+
+	r := NewReplicas(&fakeSource{name: "primary"}, &fakeSource{name: "r1"},
+		unreachable, &fakeSource{name: "r2"})
+
+	// connections are balanced across the reachable replicas
+	counts := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		counts[connName(r.Get())]++
+	}
+
+	if counts["r1"] < 9 || counts["r2"] < 9 {
+		t.Fatalf("expected 10 connections to every replica, got %v", counts)
+	}
+
+	// replicas that fail are skipped
+	r = NewReplicas(&fakeSource{name: "primary"},
+		&fakeSource{name: "r1", err: ReplyError("LOADING dataset")},
+		&fakeSource{name: "r2", err: errors.New("connection reset")},
+		&fakeSource{name: "r3", err: ReplyError("ERR wrong type")})
+
+	for i := 0; i < 6; i++ {
+		connName(r.Get())
+	}
+
+	for i := 0; i < 3; i++ {
+		if n := connName(r.Get()); n != "r3" {
+			t.Fatalf("expected a connection to r3, got %s", n)
+		}
+	}
+
+	r = NewReplicas(&fakeSource{name: "primary"}, unreachable)
+	if n := connName(r.Get()); n != "primary" {
+		t.Fatalf("expected a connection to the primary, got %s", n)
+	}
+
+	// a command that fails on a replica is retried on the primary
+	r = NewReplicas(&fakeSource{name: "primary"},
+		&fakeSource{name: "r1", err: errors.New("connection reset")})
+
+	conn := r.Get()
+	reply, err := conn.Do("PING")
+	if err != nil || reply != "primary" {
+		t.Fatalf("expected the reply of the primary, got %v %v", reply, err)
+	}
+
+	if reply, _ := conn.Do("PING"); reply != "primary" {
+		t.Fatalf("expected the connection to stay on the primary, got %v",
+			reply)
+	}
+
+	conn.Close()
+
+	// replies of a pipeline are received from the primary
+	r = NewReplicas(&fakeSource{name: "primary"},
+		&fakeSource{name: "r1", err: ReplyError("MASTERDOWN link is down")})
+
+	conn = r.Get()
+	replies, err := conn.Pipeline([]Command{{Name: "PING"}})
+	if err != nil || len(replies) != 1 || replies[0] != "primary" {
+		t.Fatalf("expected the reply of the primary, got %v %v", replies,
+			err)
+	}
+
+	conn.Close()
+}
+
+func TestSearchReads(t *testing.T) {
+	a, err := New(&fakeSource{name: "primary"}, "ac", PrefixesIndexing,
+		WithReadSource(NewReplicas(&fakeSource{name: "primary"},
+			&fakeSource{name: "r1"}, &fakeSource{name: "r2"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the reads of a search are served by a single replica
+	s := a.searchService()
+	replica := connName(s.reads.Get())
+	for i := 0; i < 5; i++ {
+		if n := connName(s.reads.Get()); n != replica {
+			t.Fatalf("expected connections to %s, got %s", replica, n)
+		}
+	}
+
+	// and by the primary once an intersection is stored there
+	s.intersectionConn([]string{"a", "b"}).Close()
+	if n := connName(s.reads.Get()); n != "primary" {
+		t.Fatalf("expected a connection to the primary, got %s", n)
+	}
+
+	if n := connName(a.reads.Get()); n == "primary" {
+		t.Fatal("expected the reads of the service to stay on the replicas")
+	}
+}
@@ -0,0 +1,175 @@
+package autocomplete
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ReplyError is an error reply of Redis
+type ReplyError string
+
+func (e ReplyError) Error() string {
+	return string(e)
+}
+
+// errNil is returned by the reply conversions for nil replies
+var errNil = errors.New("nil reply")
+
+func replyString(reply interface{}, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+
+	switch reply := reply.(type) {
+	case []byte:
+		return string(reply), nil
+	case string:
+		return reply, nil
+	case nil:
+		return "", errNil
+	case ReplyError:
+		return "", reply
+	}
+
+	return "", fmt.Errorf("unexpected reply type %T for a string", reply)
+}
+
+func replyInt64(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	switch reply := reply.(type) {
+	case int64:
+		return reply, nil
+	case []byte:
+		return strconv.ParseInt(string(reply), 10, 64)
+	case nil:
+		return 0, errNil
+	case ReplyError:
+		return 0, reply
+	}
+
+	return 0, fmt.Errorf("unexpected reply type %T for an integer", reply)
+}
+
+func replyInt(reply interface{}, err error) (int, error) {
+	n, err := replyInt64(reply, err)
+	return int(n), err
+}
+
+func replyUint64(reply interface{}, err error) (uint64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	switch reply := reply.(type) {
+	case int64:
+		if reply < 0 {
+			return 0, fmt.Errorf("unexpected negative integer %d", reply)
+		}
+
+		return uint64(reply), nil
+	case []byte:
+		return strconv.ParseUint(string(reply), 10, 64)
+	case nil:
+		return 0, errNil
+	case ReplyError:
+		return 0, reply
+	}
+
+	return 0, fmt.Errorf("unexpected reply type %T for an integer", reply)
+}
+
+func replyFloat64(reply interface{}, err error) (float64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	switch reply := reply.(type) {
+	case []byte:
+		return strconv.ParseFloat(string(reply), 64)
+	case int64:
+		return float64(reply), nil
+	case nil:
+		return 0, errNil
+	case ReplyError:
+		return 0, reply
+	}
+
+	return 0, fmt.Errorf("unexpected reply type %T for a float", reply)
+}
+
+func replyBool(reply interface{}, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+
+	switch reply := reply.(type) {
+	case int64:
+		return reply != 0, nil
+	case []byte:
+		return strconv.ParseBool(string(reply))
+	case nil:
+		return false, errNil
+	case ReplyError:
+		return false, reply
+	}
+
+	return false, fmt.Errorf("unexpected reply type %T for a bool", reply)
+}
+
+func replyValues(reply interface{}, err error) ([]interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	switch reply := reply.(type) {
+	case []interface{}:
+		return reply, nil
+	case nil:
+		return nil, errNil
+	case ReplyError:
+		return nil, reply
+	}
+
+	return nil, fmt.Errorf("unexpected reply type %T for an array", reply)
+}
+
+// replyStrings converts an array reply to strings, nil elements are empty
+func replyStrings(reply interface{}, err error) ([]string, error) {
+	values, err := replyValues(reply, err)
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+
+		if strs[i], err = replyString(v, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return strs, nil
+}
+
+func replyInts(reply interface{}, err error) ([]int, error) {
+	values, err := replyValues(reply, err)
+	if err != nil {
+		return nil, err
+	}
+
+	ints := make([]int, len(values))
+	for i, v := range values {
+		if ints[i], err = replyInt(v, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return ints, nil
+}
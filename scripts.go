@@ -0,0 +1,364 @@
+package autocomplete
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ErrKeyNotFound is returned when a script does not find the document key it
+// updates in an index ZSET or in the documents hash
+var ErrKeyNotFound = errors.New("key not found")
+
+// ScriptError is a failure of a server side script, the script is not defined
+// by the service or the server failed to run it
+type ScriptError struct {
+	Script string
+	Err    error
+}
+
+func (e *ScriptError) Error() string {
+	return "script " + e.Script + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause of the failure
+func (e *ScriptError) Unwrap() error {
+	return e.Err
+}
+
+// ScriptsStatus is the health of the scripts of the service on a connection
+// target
+type ScriptsStatus struct {
+	// Target is the address of the Redis server, or the role of the
+	// connection source when its address is unknown
+	Target string
+
+	// Missing are the names of the scripts that are not loaded
+	Missing []string
+
+	// Err is the error checking the scripts, the target is unhealthy
+	Err error
+}
+
+// Healthy returns whether all of the scripts are loaded on the target
+func (s ScriptsStatus) Healthy() bool {
+	return s.Err == nil && len(s.Missing) == 0
+}
+
+// target is a Redis server that scripts are loaded on
+type target struct {
+	name   string
+	source ConnSource
+}
+
+// multiTarget is implemented by connection sources that connect to more than
+// one Redis server
+type multiTarget interface {
+	targets() []target
+}
+
+func targetsOf(name string, source ConnSource) []target {
+	if m, ok := source.(multiTarget); ok {
+		return m.targets()
+	}
+
+	return []target{{name: name, source: source}}
+}
+
+// targets returns the servers of the primary and the read connection
+// sources, servers of both are returned once
+func (a *Autocomplete) targets() []target {
+	all := targetsOf("primary", a.pool)
+	if !sameSource(a.reads, a.pool) {
+		all = append(all, targetsOf("reads", a.reads)...)
+	}
+
+	targets := []target{}
+	seen := make(map[string]bool)
+
+	for _, t := range all {
+
+		if !seen[t.name] {
+			seen[t.name] = true
+			targets = append(targets, t)
+		}
+	}
+
+	return targets
+}
+
+// sameSource returns whether two connection sources are the same value,
+// sources of types that can not be compared are different
+func sameSource(s1, s2 ConnSource) bool {
+	t := reflect.TypeOf(s1)
+	return t == reflect.TypeOf(s2) && t.Comparable() && s1 == s2
+}
+
+func (r *Replicas) targets() []target {
+	targets := targetsOf("primary", r.primary)
+	for i, replica := range r.replicas {
+		targets = append(targets, targetsOf(fmt.Sprintf("replica %d", i),
+			replica)...)
+	}
+
+	return targets
+}
+
+func (c *Cluster) targets() []target {
+	c.mutex.RLock()
+	addrs := []string{}
+	seen := make(map[string]bool)
+	for _, addr := range c.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	c.mutex.RUnlock()
+
+	sort.Strings(addrs)
+
+	targets := []target{}
+	for _, addr := range addrs {
+		targets = append(targets, target{name: addr, source: c.source(addr)})
+	}
+
+	return targets
+}
+
+func (s *Sentinel) targets() []target {
+	return []target{{name: s.Master(), source: s}}
+}
+
+// script is a Lua script that is run by its SHA1 digest, its source is sent
+// when the server does not have it
+type script struct {
+	keyCount int
+	src      string
+	hash     string
+}
+
+func newScript(keyCount int, src string) *script {
+	h := sha1.Sum([]byte(src))
+
+	return &script{keyCount: keyCount, src: src,
+		hash: hex.EncodeToString(h[:])}
+}
+
+// args returns the arguments of EVAL or EVALSHA, scripts with a negative key
+// count take the number of keys as their first argument
+func (s *script) args(spec string, keysAndArgs []interface{}) []interface{} {
+	if s.keyCount < 0 {
+		return append([]interface{}{spec}, keysAndArgs...)
+	}
+
+	return append([]interface{}{spec, s.keyCount}, keysAndArgs...)
+}
+
+// Do runs the script, its source is sent when the server does not have it
+func (s *script) Do(conn *redisConn,
+	keysAndArgs ...interface{}) (interface{}, error) {
+
+	reply, err := conn.Do("EVALSHA", s.args(s.hash, keysAndArgs)...)
+	if e, ok := err.(ReplyError); ok && strings.HasPrefix(string(e),
+		"NOSCRIPT ") {
+
+		reply, err = conn.Do("EVAL", s.args(s.src, keysAndArgs)...)
+	}
+
+	return reply, err
+}
+
+// Send queues the script with its source, so it runs in pipelines and
+// transactions whether the server has it or not
+func (s *script) Send(conn *redisConn, keysAndArgs ...interface{}) error {
+	return conn.Send("EVAL", s.args(s.src, keysAndArgs)...)
+}
+
+// Load loads the script on the server of a connection
+func (s *script) Load(conn *redisConn) error {
+	_, err := conn.Do("SCRIPT", "LOAD", s.src)
+	return err
+}
+
+// addScript defines a script of the service
+func (a *Autocomplete) addScript(name string, keyCount int, src string) {
+	a.scripts[name] = newScript(keyCount, src)
+}
+
+// scriptNames returns the sorted names of the scripts of the service
+func (a *Autocomplete) scriptNames() []string {
+	names := []string{}
+	for name := range a.scripts {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// script returns a script of the service
+func (a *Autocomplete) script(name string) (*script, error) {
+	script, ok := a.scripts[name]
+	if !ok {
+		return nil, &ScriptError{Script: name,
+			Err: errors.New("script is not defined")}
+	}
+
+	return script, nil
+}
+
+// runScript runs a script of the service, a script that does not find the
+// document key it updates fails with ErrKeyNotFound
+func (a *Autocomplete) runScript(conn *redisConn, name string,
+	keysAndArgs ...interface{}) (interface{}, error) {
+
+	script, err := a.script(name)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := script.Do(conn, keysAndArgs...)
+	if e, ok := err.(ReplyError); ok {
+		if s := string(e); strings.HasPrefix(s, "key not found in ") {
+			return nil, fmt.Errorf("%w in %s", ErrKeyNotFound,
+				strings.TrimPrefix(s, "key not found in "))
+		}
+
+		return nil, &ScriptError{Script: name, Err: e}
+	}
+
+	return reply, err
+}
+
+// LoadScripts loads all of the scripts of the service on every Redis server
+// of the primary and the read connection sources, so the first use of a
+// script does not send its source. scripts are reloaded automatically when a
+// server lost them, e.g. after a restart or SCRIPT FLUSH.
+//
+// the context is checked between the commands, which are not interrupted.
+func (a *Autocomplete) LoadScripts(ctx context.Context) error {
+	return a.loadScripts(ctx, a.scriptNames()...)
+}
+
+func (a *Autocomplete) loadScripts(ctx context.Context, names ...string) error {
+	for _, t := range a.targets() {
+		if err := a.loadTarget(ctx, t, names); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Autocomplete) loadTarget(ctx context.Context, t target,
+	names []string) error {
+
+	conn := newRedisConn(t.source.Get())
+	defer conn.Close()
+
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		script, err := a.script(name)
+		if err != nil {
+			return err
+		}
+
+		if err := script.Load(conn); err != nil {
+			return fmt.Errorf("loading script %s on %s: %w", name, t.name,
+				err)
+		}
+	}
+
+	return nil
+}
+
+// CheckScripts reports the scripts of the service that are not loaded on
+// every Redis server of the primary and the read connection sources, servers
+// that can not be checked are reported with their error
+func (a *Autocomplete) CheckScripts(ctx context.Context) ([]ScriptsStatus,
+	error) {
+
+	statuses := []ScriptsStatus{}
+	for _, t := range a.targets() {
+		if err := ctx.Err(); err != nil {
+			return statuses, err
+		}
+
+		statuses = append(statuses, a.checkScripts(t))
+	}
+
+	return statuses, nil
+}
+
+func (a *Autocomplete) checkScripts(t target) ScriptsStatus {
+	status := ScriptsStatus{Target: t.name, Missing: []string{}}
+
+	names := a.scriptNames()
+	args := []interface{}{"EXISTS"}
+	for _, name := range names {
+		args = append(args, a.scripts[name].hash)
+	}
+
+	conn := newRedisConn(t.source.Get())
+	defer conn.Close()
+
+	exists, err := replyInts(conn.Do("SCRIPT", args...))
+	if err != nil {
+		status.Err = err
+		return status
+	}
+
+	if len(exists) != len(names) {
+		status.Err = fmt.Errorf("invalid SCRIPT EXISTS reply %v", exists)
+		return status
+	}
+
+	for i, name := range names {
+		if exists[i] == 0 {
+			status.Missing = append(status.Missing, name)
+		}
+	}
+
+	return status
+}
+
+// LoadScripts loads the scripts of the services of all shards, see
+// Autocomplete.LoadScripts
+func (s *Sharded) LoadScripts(ctx context.Context) error {
+	for _, a := range s.shards {
+		if err := a.LoadScripts(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckScripts reports the missing scripts of the services of all shards,
+// targets are prefixed with the position of their shard
+func (s *Sharded) CheckScripts(ctx context.Context) ([]ScriptsStatus, error) {
+	statuses := []ScriptsStatus{}
+	for i, a := range s.shards {
+		shard, err := a.CheckScripts(ctx)
+		for _, status := range shard {
+			status.Target = fmt.Sprintf("shard %d %s", i, status.Target)
+			statuses = append(statuses, status)
+		}
+
+		if err != nil {
+			return statuses, err
+		}
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,102 @@
+package autocomplete
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRunScript(t *testing.T) {
+	a, err := New(&fakeSource{}, "ac", PrefixesIndexing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notFound := &fakeSource{err: ReplyError("key not found in zset")}
+	_, err = a.runScript(notFound.conn(), "updateScore")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	if err.Error() != "key not found in zset" {
+		t.Fatalf("unexpected message %q", err)
+	}
+
+	var se *ScriptError
+
+	failed := &fakeSource{err: ReplyError("ERR Error running script")}
+	_, err = a.runScript(failed.conn(), "updateScore")
+	if !errors.As(err, &se) || se.Script != "updateScore" ||
+		errors.Is(err, ErrKeyNotFound) {
+
+		t.Fatalf("expected a script error, got %v", err)
+	}
+
+	_, err = a.runScript((&fakeSource{}).conn(), "missing")
+	if !errors.As(err, &se) || se.Script != "missing" {
+		t.Fatalf("expected a script error, got %v", err)
+	}
+
+	// network errors are not script failures
+	network := errors.New("connection reset")
+	_, err = a.runScript((&fakeSource{err: network}).conn(), "updateScore")
+	if err != network {
+		t.Fatalf("expected the network error, got %v", err)
+	}
+}
+
+func TestScript(t *testing.T) {
+	cmds := [][]interface{}{}
+	source := &fakeSource{handle: func(cmd string,
+		args []interface{}) (interface{}, error) {
+
+		cmds = append(cmds, append([]interface{}{cmd}, args...))
+		if cmd == "EVALSHA" {
+			return nil, ReplyError("NOSCRIPT No matching script")
+		}
+
+		return int64(1), nil
+	}}
+
+	fixed := newScript(1, "return 1")
+	if _, err := fixed.Do(source.conn(), "k", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// scripts with a variable number of keys are given the count
+	variadic := newScript(-1, "return 1")
+	if _, err := variadic.Do(source.conn(), 1, "k", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]interface{}{
+		{"EVALSHA", fixed.hash, 1, "k", "a"},
+		{"EVAL", "return 1", 1, "k", "a"},
+		{"EVALSHA", variadic.hash, 1, "k", "a"},
+		{"EVAL", "return 1", 1, "k", "a"},
+	}
+
+	if !reflect.DeepEqual(cmds, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmds)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	a, err := New(&fakeSource{name: "p"}, "ac", PrefixesIndexing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.SetReadSource(NewReplicas(&fakeSource{name: "p"},
+		&fakeSource{name: "r1"}, &fakeSource{name: "r2"}))
+
+	names := []string{}
+	for _, t := range a.targets() {
+		names = append(names, t.name)
+	}
+
+	expected := []string{"primary", "replica 0", "replica 1"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+}
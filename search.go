@@ -1,13 +1,11 @@
 package autocomplete
 
 import (
-	"bytes"
 	"fmt"
 	"sort"
 	"strings"
 	"sync"
-
-	"github.com/garyburd/redigo/redis"
+	"time"
 )
 
 // Sort constants
@@ -16,304 +14,537 @@ const (
 	SortRevLexicographical = 1
 	SortScore              = 2
 	SortRevScore           = 3
+
+	// SortRelevance combines the score with the quality of the match of the
+	// query in the term, see RelevanceWeights
+	SortRelevance = 4
 )
 
+// SearchOptions are the optional parameters of an autocomplete search query
+type SearchOptions struct {
+	// Sort is the order of the results, one of the Sort constants
+	Sort int
+
+	// Phonetic appends documents with words that sound like the query words
+	// to the results, ranked below the literal prefix matches.
+	//
+	// it requires the phonetic index to be enabled with SetPhonetic
+	Phonetic bool
+
+	// Phrase only matches documents whose terms contain the query words
+	// in the same order and adjacent to each other, so "new yo" matches
+	// "New York" but not "York New Station".
+	//
+	// terms indexing always matches the query as a phrase
+	Phrase bool
+
+	// InOrderBoost ranks documents whose terms contain the query words in
+	// the same order above documents that contain them in a different order
+	InOrderBoost bool
+
+	// ClickBoost ranks documents that were selected for the same query
+	// above other documents, by their number of selections.
+	//
+	// it requires click tracking to be enabled with SetClickTracking
+	ClickBoost bool
+
+	// User personalizes the results for a user, the documents recently
+	// selected by the user are boosted as configured by SetPersonalization
+	User string
+
+	// SpellRetry retries a search that matches no documents with the query
+	// corrected by SpellSuggest, if there is a correction
+	SpellRetry bool
+
+	// ReadYourWrites serves the search from the primary connection source
+	// instead of the read source, so it observes all of the completed writes
+	ReadYourWrites bool
+
+	// Limit is the maximum number of results, 0 returns all of the results
+	Limit int
+}
+
 // Search invokes an autocomplete search query
 func (a *Autocomplete) Search(index, query string, sort int) ([][]byte, error) {
-	switch a.indexType {
-	case PrefixesIndexing:
-		return a.prefixesSearch(index, query, sort)
-
-	case TermsIndexing:
-		return a.termsSearch(index, query, sort)
-
-	default:
-		return [][]byte{}, ErrInvalidIndexType
-	}
+	return a.SearchWithOptions(index, query, SearchOptions{Sort: sort})
 }
 
-func (a *Autocomplete) prefixesSearch(index, query string,
-	orderBy int) ([][]byte, error) {
+// SearchWithOptions invokes an autocomplete search query with the given
+// options
+func (a *Autocomplete) SearchWithOptions(index, query string,
+	opts SearchOptions) (results [][]byte, err error) {
 
-	conn := a.pool.Get()
-	defer conn.Close()
+	var hit bool
 
-	terms := strings.Split(strings.ToLower(query), " ")
+	if a.metrics != nil {
+		start := time.Now()
+		defer func() {
+			a.metrics.ObserveSearch(index, time.Since(start), len(results), hit,
+				err)
+		}()
+	}
 
-	// remove empty terms
-	v := []string{}
-	for _, t := range terms {
-		if t != "" {
-			v = append(v, t)
-		}
+	if opts.ReadYourWrites {
+		a = a.primary()
+	} else {
+		a = a.searchService()
 	}
 
-	terms = v
+	cached := a.cache != nil && !opts.ReadYourWrites
+	// searches are case insensitive, other differences in the query such as
+	// trailing spaces change terms indexing results
+	ckey := cacheKey{index: index, query: strings.ToLower(query), opts: opts}
 
-	if len(terms) == 0 {
-		return [][]byte{}, nil
+	var executed string
+	var version uint64
+
+	if cached {
+		results, executed, version, hit = a.cache.get(ckey)
 	}
 
-	var zkey string
-	idx := a.prefix + ":$" + index
+	if !hit {
+		serverSide, err := a.serverSideSearch(index, opts)
+		if err != nil {
+			return [][]byte{}, err
+		}
+
+		if serverSide {
+			executed = query
+			results, err = a.scriptSearch(index, query, opts)
+		} else {
+			var keys []string
 
-	if len(terms) == 1 {
-		zkey = a.prefix + ":" + index + ":" + terms[0]
-	} else {
-		buf := bytes.NewBufferString(idx + ":")
-		for i, t := range terms {
-			buf.WriteString(t)
-			if i < len(terms)-1 {
-				buf.WriteString("|")
+			keys, executed, err = a.searchKeys(index, query, opts)
+			if err != nil {
+				return [][]byte{}, err
 			}
-		}
 
-		zkey = buf.String()
+			results, err = a.fetch(index, keys)
+		}
 
-		keys := []string{}
-		for _, t := range terms {
-			keys = append(keys, a.prefix+":"+index+":"+t)
+		if err != nil {
+			return [][]byte{}, err
 		}
 
-		args := []interface{}{zkey, len(terms)}
-		for _, k := range keys {
-			args = append(args, k)
+		if cached {
+			a.cache.add(ckey, executed, results, version)
 		}
+	}
 
-		args = append(args, []interface{}{"AGGREGATE", "MAX"}...)
-		if _, err := conn.Do("ZINTERSTORE", args...); err != nil {
+	if a.queryRetention > 0 {
+		if err := a.RecordQuery(index, executed); err != nil {
 			return [][]byte{}, err
 		}
 	}
 
-	var values []interface{}
-	var err error
+	return results, nil
+}
 
-	switch orderBy {
-	case SortLexicographical:
-		values, err = redis.Values(conn.Do("ZRANGE", zkey, 0, -1))
+// searchKeys returns the ordered document keys of the results of a search
+// query and the executed query, which is the spelling correction of the query
+// when a search is retried
+func (a *Autocomplete) searchKeys(index, query string,
+	opts SearchOptions) ([]string, string, error) {
 
-	case SortRevLexicographical:
-		values, err = redis.Values(conn.Do("ZREVRANGE", zkey, 0, -1))
+	keys, err := a.matchKeys(index, query, opts.Sort)
+	if err != nil {
+		return []string{}, query, err
+	}
 
-	case SortScore:
-		values, err = redis.Values(conn.Do("ZRANGEBYSCORE", zkey, "-inf", "+inf"))
+	if len(keys) == 0 && opts.SpellRetry {
+		suggestion, err := a.SpellSuggest(index, query)
+		if err != nil {
+			return []string{}, query, err
+		}
 
-	case SortRevScore:
-		values, err = redis.Values(conn.Do("ZREVRANGEBYSCORE", zkey, "+inf", "-inf"))
+		if suggestion != "" {
+			opts.SpellRetry = false
+			return a.searchKeys(index, suggestion, opts)
+		}
+	}
+
+	var scores []float64
+
+	_, decays := a.halfLives[index]
+	if len(keys) > 0 && (opts.Sort == SortRelevance ||
+		decays && (opts.Sort == SortScore || opts.Sort == SortRevScore)) {
+
+		scores, err = a.decayedScores(index, keys)
+		if err != nil {
+			return []string{}, query, err
+		}
 	}
 
+	ranked, err := a.rankKeys(index, query, opts, keys, scores,
+		maxScore(scores))
 	if err != nil {
-		return [][]byte{}, err
+		return []string{}, query, err
+	}
+
+	sortRanked(ranked, opts.Sort, false)
+
+	keys = rankedKeys(ranked)
+	if opts.Limit > 0 && len(keys) > opts.Limit {
+		keys = keys[:opts.Limit]
+	}
+
+	return keys, query, nil
+}
+
+// matchKeys returns the document keys that match a search query literally,
+// in the given sort order
+func (a *Autocomplete) matchKeys(index, query string,
+	orderBy int) ([]string, error) {
+
+	l, err := a.layout(index)
+	if err != nil {
+		return []string{}, err
+	}
+
+	switch l.indexType {
+	case PrefixesIndexing:
+		return a.prefixesSearch(index, query, orderBy)
+
+	case TermsIndexing:
+		return a.termsSearch(index, query, orderBy)
+
+	default:
+		return []string{}, ErrInvalidIndexType
+	}
+}
+
+// queryTerms splits a search query to its lower case words
+func queryTerms(query string) []string {
+	terms := []string{}
+	for _, t := range strings.Split(strings.ToLower(query), " ") {
+		if t != "" {
+			terms = append(terms, t)
+		}
+	}
+
+	return terms
+}
+
+func (a *Autocomplete) prefixesSearch(index, query string,
+	orderBy int) ([]string, error) {
+
+	terms := a.analyze(query)
+	if len(terms) == 0 {
+		return []string{}, nil
 	}
 
 	keys := []string{}
-	for _, r := range values {
-		b, ok := r.([]byte)
-		if !ok {
-			return [][]byte{}, fmt.Errorf("type assertion error")
+	for _, t := range terms {
+		keys = append(keys, a.prefixKey(index, t))
+	}
+
+	conn := a.intersectionConn(keys)
+	defer conn.Close()
+
+	if len(keys) == 1 {
+		return a.rangeZSet(conn, keys[0], orderBy)
+	}
+
+	// the intersection of the previous query contains this one's
+	zkey := a.indexKey(intersectionKind, index, terms...)
+	previous := zkey
+	if p := previousTerms(terms); len(p) > 1 {
+		previous = a.indexKey(intersectionKind, index, p...)
+	}
+
+	return a.intersectAndRange(conn, index, zkey, previous, keys, orderBy)
+}
+
+func (a *Autocomplete) phoneticSearch(index, query string,
+	orderBy int) ([]string, error) {
+
+	codes := []string{}
+	for _, t := range a.analyze(query) {
+		if c := metaphone(t); c != "" {
+			codes = append(codes, c)
 		}
+	}
 
-		keys = append(keys, string(b))
+	if len(codes) == 0 {
+		return []string{}, nil
 	}
 
-	if orderBy == SortLexicographical {
-		sort.Sort(sort.StringSlice(keys))
-	} else if orderBy == SortRevLexicographical {
-		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	keys := []string{}
+	for _, c := range codes {
+		keys = append(keys, a.phoneticKey(index, c))
 	}
 
-	results := [][]byte{}
-	queries := make([][]string, int(len(keys)/1000)+1)
-	queryResults := make(map[int][]interface{})
-	var queryResultsMutex sync.RWMutex
+	conn := a.intersectionConn(keys)
+	defer conn.Close()
 
-	for i, k := range keys {
-		queries[int(i/1000)] = append(queries[int(i/1000)], k)
+	if len(keys) == 1 {
+		return a.rangeZSet(conn, keys[0], orderBy)
 	}
 
-	var wg sync.WaitGroup
-	e := make(chan error, len(queries)+1)
+	// codes do not narrow when characters are added
+	zkey := a.phoneticKey(index, strings.Join(codes, "|"))
+	return a.intersectAndRange(conn, index, zkey, zkey, keys, orderBy)
+}
 
-	for i, keys := range queries {
-		if len(keys) == 0 {
-			continue
+// intersectionConn returns a connection for ranging over the intersection of
+// the given ZSETs, intersections are stored so they are computed on the
+// primary, which serves the rest of the reads of the search
+func (a *Autocomplete) intersectionConn(keys []string) *redisConn {
+	if len(keys) > 1 {
+		if s, ok := a.reads.(*searchReads); ok {
+			s.usePrimary()
 		}
 
-		wg.Add(1)
-		go func(i int, keys []string) {
-			defer wg.Done()
+		return a.conn()
+	}
 
-			conn := a.pool.Get()
-			defer conn.Close()
+	return a.readConn()
+}
 
-			args := []interface{}{idx}
-			for _, k := range keys {
-				args = append(args, k)
-			}
+// intersectAndRange returns the members of the intersection of the given
+// ZSETs in the requested order. the intersection is stored in zkey with the
+// intersection TTL unless zkey exists, it is computed from the intersection
+// stored in previous and the last ZSET when previous exists. stored
+// intersections are deleted by writes to the index.
+func (a *Autocomplete) intersectAndRange(conn *redisConn, index, zkey,
+	previous string, keys []string, orderBy int) ([]string, error) {
+
+	order := map[int]string{
+		SortLexicographical:    "lex",
+		SortRevLexicographical: "revlex",
+		SortScore:              "score",
+		SortRevScore:           "revscore",
+		SortRelevance:          "revscore",
+	}[orderBy]
+
+	args := []interface{}{len(keys) + 3, zkey, a.intersectionsKey(index),
+		previous}
+	for _, k := range keys {
+		args = append(args, k)
+	}
 
-			values, err := redis.Values(conn.Do("HMGET", args...))
-			if err != nil {
-				e <- err
-				return
-			}
-			queryResultsMutex.Lock()
-			defer queryResultsMutex.Unlock()
-			queryResults[i] = values
-		}(i, keys)
+	args = append(args, a.intersectionMillis(), order)
+
+	members, err := replyStrings(a.runScript(conn, "intersectRange",
+		args...))
+	if err != nil {
+		return []string{}, err
 	}
 
-	if len(terms) > 1 {
-		go func() {
-			conn := a.pool.Get()
-			defer conn.Close()
+	return sortMembers(members, orderBy), nil
+}
 
-			if err := conn.Send("EXPIRE", zkey, 60); err != nil {
-				e <- err
-			}
-		}()
+// rangeZSet returns the members of a ZSET in the requested order
+func (a *Autocomplete) rangeZSet(conn *redisConn, zkey string,
+	orderBy int) ([]string, error) {
+
+	var values []interface{}
+	var err error
+
+	switch orderBy {
+	case SortLexicographical:
+		values, err = replyValues(conn.Do("ZRANGE", zkey, 0, -1))
+
+	case SortRevLexicographical:
+		values, err = replyValues(conn.Do("ZREVRANGE", zkey, 0, -1))
+
+	case SortScore:
+		values, err = replyValues(conn.Do("ZRANGEBYSCORE", zkey, "-inf", "+inf"))
+
+	case SortRevScore, SortRelevance:
+		values, err = replyValues(conn.Do("ZREVRANGEBYSCORE", zkey, "+inf",
+			"-inf"))
 	}
 
-	wg.Wait()
-	if len(e) > 0 {
-		return [][]byte{}, <-e
+	if err != nil {
+		return []string{}, err
 	}
 
-	for _, q := range queryResults {
-		for _, v := range q {
-			b, ok := v.([]byte)
-			if !ok {
-				return [][]byte{}, fmt.Errorf("type assertion error")
-			}
+	members, err := replyStrings(values, nil)
+	if err != nil {
+		return []string{}, err
+	}
 
-			results = append(results, b)
-		}
+	return sortMembers(members, orderBy), nil
+}
+
+// sortMembers sorts members in lexicographical orders, ZSETs are ranged by
+// score
+func sortMembers(members []string, orderBy int) []string {
+	if orderBy == SortLexicographical {
+		sort.Sort(sort.StringSlice(members))
+	} else if orderBy == SortRevLexicographical {
+		sort.Sort(sort.Reverse(sort.StringSlice(members)))
 	}
 
-	return results, nil
+	return members
 }
 
 func (a *Autocomplete) termsSearch(index, query string,
-	orderBy int) ([][]byte, error) {
+	orderBy int) ([]string, error) {
 
-	conn := a.pool.Get()
+	conn := a.readConn()
 	defer conn.Close()
 
 	var values []interface{}
 	var err error
 
-	zkey := a.prefix + ":$$" + index
-	q := strings.ToLower(query)
+	zkey := a.termsIndexKey(index)
+	min, max := a.prefixRange(query)
 
 	switch orderBy {
 	case SortScore:
 		fallthrough
 	case SortRevScore:
 		fallthrough
+	case SortRelevance:
+		fallthrough
 	case SortLexicographical:
-		values, err = redis.Values(conn.Do("ZRANGEBYLEX", zkey, "["+q, "["+q+"\xff"))
+		values, err = replyValues(conn.Do("ZRANGEBYLEX", zkey, min, max))
 
 	case SortRevLexicographical:
-		values, err = redis.Values(conn.Do("ZREVRANGEBYLEX", zkey, "["+q+"\xff", "["+q))
+		values, err = replyValues(conn.Do("ZREVRANGEBYLEX", zkey, max, min))
 	}
 
 	if err != nil {
-		return [][]byte{}, err
+		return []string{}, err
 	}
 
-	vals := []string{}
-	for _, r := range values {
-		b, ok := r.([]byte)
-		if !ok {
-			return [][]byte{}, fmt.Errorf("type assertion error")
+	vals, err := replyStrings(values, nil)
+	if err != nil {
+		return []string{}, err
+	}
+
+	members := make(byScore, len(vals))
+	for i, v := range vals {
+		_, score, docKey, err := a.decodeMember(v)
+		if err != nil {
+			return []string{}, err
 		}
 
-		vals = append(vals, string(b))
+		members[i] = scoredKey{docKey, score}
 	}
 
 	if orderBy == SortScore {
-		sort.Sort(byScore(vals))
-	} else if orderBy == SortRevScore {
-		sort.Sort(sort.Reverse(byScore(vals)))
+		sort.Sort(members)
+	} else if orderBy == SortRevScore || orderBy == SortRelevance {
+		sort.Sort(sort.Reverse(members))
 	}
 
 	keys := []string{}
-	for _, v := range vals {
-		parts := strings.Split(v, "::")
-		key := parts[len(parts)-1]
-		keys = append(keys, key)
+	for _, m := range members {
+		keys = append(keys, m.docKey)
+	}
+
+	return keys, nil
+}
+
+// fetch returns the stored documents of the given document keys, documents
+// that were removed since their keys were read are skipped
+func (a *Autocomplete) fetch(index string, keys []string) ([][]byte, error) {
+	values, err := a.hmget(a.documentsKey(index), keys)
+	if err != nil {
+		return [][]byte{}, err
 	}
 
 	results := [][]byte{}
-	queries := make([][]string, int(len(keys)/1000)+1)
-	queryResults := make(map[int][]interface{})
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+
+		b, ok := v.([]byte)
+		if !ok {
+			return [][]byte{}, fmt.Errorf("type assertion error")
+		}
+
+		results = append(results, b)
+	}
+
+	return results, nil
+}
+
+// hmget returns the values of the given fields of a hash, in batches of
+// fields which are fetched concurrently up to the fetch concurrency
+func (a *Autocomplete) hmget(hkey string, keys []string) ([]interface{}, error) {
+	results := []interface{}{}
+	queries := make([][]string, int(len(keys)/a.batchSize)+1)
+	queryResults := make([][]interface{}, len(queries))
 
 	for i, k := range keys {
-		queries[int(i/1000)] = append(queries[int(i/1000)], k)
+		queries[int(i/a.batchSize)] = append(queries[int(i/a.batchSize)], k)
 	}
 
 	var wg sync.WaitGroup
 	e := make(chan error, len(queries))
 
+	var sem chan struct{}
+	if a.fetchConcurrency > 0 {
+		sem = make(chan struct{}, a.fetchConcurrency)
+	}
+
 	for i, keys := range queries {
 		if len(keys) == 0 {
 			continue
 		}
 
+		if sem != nil {
+			sem <- struct{}{}
+		}
+
 		wg.Add(1)
 		go func(i int, keys []string) {
 			defer wg.Done()
 
-			conn := a.pool.Get()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+
+			conn := a.readConn()
 			defer conn.Close()
 
-			args := []interface{}{a.prefix + ":$" + index}
+			args := []interface{}{hkey}
 			for _, k := range keys {
 				args = append(args, k)
 			}
 
-			values, err := redis.Values(conn.Do("HMGET", args...))
+			values, err := replyValues(conn.Do("HMGET", args...))
 			if err != nil {
 				e <- err
 				return
 			}
+
 			queryResults[i] = values
 		}(i, keys)
 	}
 
 	wg.Wait()
 	if len(e) > 0 {
-		return [][]byte{}, <-e
+		return []interface{}{}, <-e
 	}
 
 	for _, q := range queryResults {
-		for _, v := range q {
-			b, ok := v.([]byte)
-			if !ok {
-				return [][]byte{}, fmt.Errorf("type assertion error")
-			}
-
-			results = append(results, b)
-		}
+		results = append(results, q...)
 	}
 
 	return results, nil
 }
 
-type byScore []string
+// scoredKey is a document key and its score decoded from a terms indexing
+// ZSET member
+type scoredKey struct {
+	docKey string
+	score  uint64
+}
+
+type byScore []scoredKey
 
 func (v byScore) Len() int {
 	return len(v)
 }
 
 func (v byScore) Less(i, j int) bool {
-	partsi := strings.Split(v[i], "::")
-	partsj := strings.Split(v[j], "::")
-
-	scorei := partsi[len(partsi)-2]
-	scorej := partsj[len(partsj)-2]
-
-	return scorei < scorej
+	return v[i].score < v[j].score
 }
 
 func (v byScore) Swap(i, j int) {
@@ -1,49 +1,35 @@
-package autocomplete
+package autocomplete_test
 
 import (
 	"encoding/json"
 	"log"
-	"time"
 
-	"github.com/garyburd/redigo/redis"
+	"github.com/augurysys/autocomplete"
+	"github.com/augurysys/autocomplete/garyburd"
 )
 
 func ExampleAutocomplete_Search() {
-	pool := &redis.Pool{
-		MaxIdle:     3,
-		MaxActive:   20,
-		IdleTimeout: 240 * time.Second,
-		Wait:        true,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", "localhost:6379")
-			if err != nil {
-				return nil, err
-			}
-
-			return c, err
-		},
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("PING")
-			return err
-		},
-	}
-
+	pool := newPool()
 	defer pool.Close()
 
-	a := New(pool, "ac", TermsIndexing)
+	a, err := autocomplete.New(garyburd.NewSource(pool), "ac",
+		autocomplete.TermsIndexing)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	results, err := a.Search("cars", "mer", SortLexicographical)
+	results, err := a.Search("cars", "mer", autocomplete.SortLexicographical)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	var docs []doc
+	var cars []car
 	for _, b := range results {
-		var d doc
-		if err := json.Unmarshal(b, &d); err != nil {
+		var c car
+		if err := json.Unmarshal(b, &c); err != nil {
 			log.Fatal(err)
 		}
 
-		docs = append(docs, d)
+		cars = append(cars, c)
 	}
 }
@@ -0,0 +1,256 @@
+package autocomplete
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxSelectionRetries is the number of times a terms indexing selection is
+// retried when its ZSET is concurrently modified
+const maxSelectionRetries = 10
+
+// maxClickedDocuments is the number of most selected documents tracked for
+// every query
+const maxClickedDocuments = 100
+
+// SetClickTracking enables or disables tracking of the documents selected for
+// every query by RecordSelection, which is required for click boosting in
+// searches
+func (a *Autocomplete) SetClickTracking(enabled bool) {
+	a.clicks = enabled
+}
+
+// RecordSelection records that a document was selected from the results of a
+// search query, the document's score is atomically incremented by 1 and when
+// click tracking is enabled the selection is counted for the query.
+func (a *Autocomplete) RecordSelection(index, query string, d Document) error {
+	conn := a.conn()
+	defer conn.Close()
+
+	l, err := a.layout(index)
+	if err != nil {
+		return err
+	}
+
+	docKey := l.docKey(d)
+
+	var score uint64
+
+	switch l.indexType {
+	case PrefixesIndexing:
+		args := []interface{}{}
+		for _, p := range a.prefixes(d) {
+			args = append(args, a.prefixKey(index, p))
+		}
+
+		if a.phonetic {
+			for _, k := range a.phoneticKeys(index, d.Term()) {
+				args = append(args, k)
+			}
+		}
+
+		args = append([]interface{}{len(args) + 1, a.documentsKey(index)},
+			args...)
+		args = append(args, docKey, 1)
+
+		s, err := replyFloat64(a.runScript(conn, "incrementScore", args...))
+		if errors.Is(err, ErrKeyNotFound) {
+			return a.notFound(conn, index, docKey, err)
+		} else if err != nil {
+			return err
+		}
+
+		score = uint64(s)
+
+	case TermsIndexing:
+		score, err = a.incrementTermsScore(conn, index, docKey, d)
+		if err != nil {
+			return err
+		}
+
+	default:
+		return ErrInvalidIndexType
+	}
+
+	// the migration target layout takes the incremented score
+	if l.migrating() {
+		if err := a.sendSecondaryIndex(conn, l.target, index, docKey, d.Term(),
+			score, false); err != nil {
+
+			return err
+		}
+
+		if err := receiveAll(conn); err != nil {
+			return err
+		}
+	}
+
+	_, decays := a.halfLives[index]
+	q := a.normalizedQuery(query)
+
+	if !decays && !(a.clicks && q != "") {
+		return a.invalidate(conn, index)
+	}
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+
+	if decays {
+		if err := conn.Send("HSET", a.activityKey(index), docKey,
+			time.Now().Unix()); err != nil {
+
+			return err
+		}
+	}
+
+	if a.clicks && q != "" {
+		ckey := a.clicksKey(index, q)
+		if err := conn.Send("ZINCRBY", ckey, 1, docKey); err != nil {
+			return err
+		}
+
+		if err := conn.Send("ZREMRANGEBYRANK", ckey, 0,
+			-maxClickedDocuments-1); err != nil {
+
+			return err
+		}
+	}
+
+	if err := replyError(conn.Do("EXEC")); err != nil {
+		return err
+	}
+
+	return a.invalidate(conn, index)
+}
+
+// incrementTermsScore increments the score of a document in a terms index by
+// rewriting its ZSET member and returns the incremented score, the member is
+// watched and the rewrite is retried if it is concurrently modified
+func (a *Autocomplete) incrementTermsScore(conn *redisConn, index,
+	docKey string, d Document) (uint64, error) {
+
+	zkey := a.termsIndexKey(index)
+
+	for i := 0; i < maxSelectionRetries; i++ {
+		if _, err := conn.Do("WATCH", zkey); err != nil {
+			return 0, err
+		}
+
+		// the removeDocument script only finds the member of the document
+		member, err := replyString(a.runScript(conn, "removeDocument", zkey,
+			a.memberSuffix(docKey)))
+		if err != nil {
+			a.unwatch(conn)
+
+			if errors.Is(err, ErrKeyNotFound) {
+				return 0, a.notFound(conn, index, docKey, err)
+			}
+
+			return 0, err
+		}
+
+		term, score, _, err := a.decodeMember(member)
+		if err != nil {
+			a.unwatch(conn)
+			return 0, err
+		}
+
+		score++
+
+		val, err := a.encodeMember(term, score, docKey)
+		if err != nil {
+			a.unwatch(conn)
+			return 0, err
+		}
+
+		if err := conn.Send("MULTI"); err != nil {
+			return 0, err
+		}
+
+		if err := conn.Send("ZREM", zkey, member); err != nil {
+			return 0, err
+		}
+
+		if err := conn.Send("ZADD", zkey, 0, val); err != nil {
+
+			return 0, err
+		}
+
+		if a.phonetic {
+			for _, k := range a.phoneticKeys(index, d.Term()) {
+				if err := conn.Send("ZADD", k, score, docKey); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		reply, err := conn.Do("EXEC")
+		if err := replyError(reply, err); err != nil {
+			return 0, err
+		}
+
+		// a nil reply means the transaction was aborted by the watch
+		if reply != nil {
+			return score, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%s was concurrently modified %d times", zkey,
+		maxSelectionRetries)
+}
+
+// clicksKey is the key of the ZSET that counts the selections of documents
+// for a normalized query
+func (a *Autocomplete) clicksKey(index, query string) string {
+	return a.indexKey(clicksKind, index, query)
+}
+
+func (a *Autocomplete) normalizedQuery(query string) string {
+	return strings.Join(a.analyze(query), " ")
+}
+
+// clickCounts returns the number of selections of the given document keys
+// for the query
+func (a *Autocomplete) clickCounts(index, query string,
+	keys []string) ([]float64, error) {
+
+	counts := make([]float64, len(keys))
+
+	q := a.normalizedQuery(query)
+	if q == "" || len(keys) == 0 {
+		return counts, nil
+	}
+
+	conn := a.readConn()
+	defer conn.Close()
+
+	values, err := replyValues(conn.Do("ZREVRANGE", a.clicksKey(index, q), 0,
+		-1, "WITHSCORES"))
+	if err != nil {
+		return []float64{}, err
+	}
+
+	clicks := make(map[string]float64)
+	for i := 0; i+1 < len(values); i += 2 {
+		member, err := replyString(values[i], nil)
+		if err != nil {
+			return []float64{}, err
+		}
+
+		count, err := replyFloat64(values[i+1], nil)
+		if err != nil {
+			return []float64{}, err
+		}
+
+		clicks[member] = count
+	}
+
+	for i, k := range keys {
+		counts[i] = clicks[k]
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,383 @@
+package autocomplete
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sentinelRetry is the time between attempts to watch the sentinels for
+// failovers when none of them can be subscribed to
+const sentinelRetry = time.Second
+
+// Sentinel is a connection source for a Redis master monitored by Redis
+// Sentinel, its connections go to the current master.
+//
+// the master is discovered from the first sentinel that knows it and
+// rediscovered when the sentinels announce a failover, when a connection to
+// the master fails and when the master replies that it is a read only
+// replica. commands that fail during a failover are not retried.
+type Sentinel struct {
+	name      string
+	newSource func(addr string) ConnSource
+
+	mutex      sync.RWMutex
+	sentinels  []string
+	sources    map[string]ConnSource
+	master     string
+	refreshing bool
+
+	watchSub Subscription
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewSentinel returns a connection source for the master with the given name
+// monitored by the sentinels with the given addresses, connections to the
+// sentinels and to the master are taken from sources created with newSource,
+// which are closed with the sentinel if they are io.Closers.
+//
+// failovers are only announced by sentinels whose sources are Subscribers,
+// the master is rediscovered when its connections fail otherwise.
+func NewSentinel(addrs []string, name string,
+	newSource func(addr string) ConnSource) (*Sentinel, error) {
+
+	s := &Sentinel{
+		name:      name,
+		newSource: newSource,
+		sentinels: addrs,
+		sources:   make(map[string]ConnSource),
+		stop:      make(chan struct{}),
+	}
+
+	if err := s.Refresh(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.watch()
+
+	return s, nil
+}
+
+// Get returns a connection to the current master, the connection must be
+// closed
+func (s *Sentinel) Get() Conn {
+	return &sentinelConn{Conn: s.source(s.Master()).Get(), s: s}
+}
+
+// Subscribe subscribes to a channel of the current master, it fails with
+// ErrPubSubUnsupported when the master's source is not a Subscriber
+func (s *Sentinel) Subscribe(channel string) (Subscription, error) {
+	sub, ok := s.source(s.Master()).(Subscriber)
+	if !ok {
+		return nil, ErrPubSubUnsupported
+	}
+
+	return sub.Subscribe(channel)
+}
+
+// Master returns the address of the current master
+func (s *Sentinel) Master() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.master
+}
+
+// Refresh discovers the master from the first sentinel that knows it, the
+// sentinel is asked first by later refreshes
+func (s *Sentinel) Refresh() error {
+	s.mutex.RLock()
+	sentinels := append([]string{}, s.sentinels...)
+	s.mutex.RUnlock()
+
+	var err error
+	for i, addr := range sentinels {
+		var master string
+		master, err = s.masterAddr(addr)
+		if err != nil {
+			continue
+		}
+
+		if err = s.checkMaster(master); err != nil {
+			continue
+		}
+
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		s.sentinels = append([]string{addr},
+			append(sentinels[:i:i], sentinels[i+1:]...)...)
+
+		if s.master != "" && s.master != master {
+			// connections to the previous master are closed when they
+			// are returned to its source
+			if p, ok := s.sources[s.master]; ok {
+				closeSource(p)
+				delete(s.sources, s.master)
+			}
+		}
+
+		s.master = master
+
+		return nil
+	}
+
+	if err == nil {
+		err = errors.New("no sentinels")
+	}
+
+	return err
+}
+
+// masterAddr asks a sentinel for the address of the master
+func (s *Sentinel) masterAddr(addr string) (string, error) {
+	conn := s.source(addr).Get()
+	defer conn.Close()
+
+	values, err := replyStrings(conn.Do("SENTINEL", "get-master-addr-by-name",
+		s.name))
+	if err == errNil {
+		return "", fmt.Errorf("%s does not monitor %s", addr, s.name)
+	} else if err != nil {
+		return "", err
+	}
+
+	if len(values) != 2 {
+		return "", fmt.Errorf("invalid master address %v", values)
+	}
+
+	return values[0] + ":" + values[1], nil
+}
+
+// checkMaster checks that the Redis server at an address is a master, a
+// sentinel can reply with the previous master right after a failover
+func (s *Sentinel) checkMaster(addr string) error {
+	conn := s.source(addr).Get()
+	defer conn.Close()
+
+	values, err := replyValues(conn.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+
+	if len(values) == 0 {
+		return errors.New("invalid ROLE reply")
+	}
+
+	role, err := replyString(values[0], nil)
+	if err != nil {
+		return err
+	}
+
+	if role != "master" {
+		return fmt.Errorf("%s is a %s", addr, role)
+	}
+
+	return nil
+}
+
+// failed refreshes the master in the background, unless a refresh is in
+// progress
+func (s *Sentinel) failed() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.refreshing {
+		return
+	}
+
+	s.refreshing = true
+	go func() {
+		s.Refresh()
+
+		s.mutex.Lock()
+		s.refreshing = false
+		s.mutex.Unlock()
+	}()
+}
+
+// watch subscribes to the failover announcements of the sentinels and
+// refreshes the master when it is switched, until the source is closed
+func (s *Sentinel) watch() {
+	defer s.wg.Done()
+
+	for {
+		s.mutex.RLock()
+		sentinels := append([]string{}, s.sentinels...)
+		s.mutex.RUnlock()
+
+		for _, addr := range sentinels {
+			err := s.watchSentinel(addr)
+			if err == ErrPubSubUnsupported {
+				return
+			}
+
+			if err != nil {
+				select {
+				case <-s.stop:
+					return
+				default:
+				}
+			}
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(sentinelRetry):
+		}
+	}
+}
+
+func (s *Sentinel) watchSentinel(addr string) error {
+	source, ok := s.source(addr).(Subscriber)
+	if !ok {
+		return ErrPubSubUnsupported
+	}
+
+	// the subscription has its own connection, so Close can interrupt it
+	sub, err := source.Subscribe("+switch-master")
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	select {
+	case <-s.stop:
+		s.mutex.Unlock()
+		sub.Close()
+		return nil
+	default:
+	}
+
+	s.watchSub = sub
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		s.watchSub = nil
+		s.mutex.Unlock()
+
+		sub.Close()
+	}()
+
+	// the master can be switched while no sentinel is watched
+	if err := s.Refresh(); err != nil {
+		return err
+	}
+
+	for {
+		m, err := sub.Receive()
+		if err != nil {
+			return err
+		}
+
+		// <name> <old ip> <old port> <new ip> <new port>
+		if strings.SplitN(string(m), " ", 2)[0] != s.name {
+			continue
+		}
+
+		if err := s.Refresh(); err != nil {
+			s.failed()
+		}
+	}
+}
+
+// Close stops watching the sentinels and closes the connection sources
+func (s *Sentinel) Close() error {
+	s.mutex.Lock()
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+
+	if s.watchSub != nil {
+		s.watchSub.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var err error
+	for addr, p := range s.sources {
+		if e := closeSource(p); e != nil && err == nil {
+			err = e
+		}
+
+		delete(s.sources, addr)
+	}
+
+	return err
+}
+
+func (s *Sentinel) source(addr string) ConnSource {
+	s.mutex.RLock()
+	p, ok := s.sources[addr]
+	s.mutex.RUnlock()
+
+	if ok {
+		return p
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if p, ok := s.sources[addr]; ok {
+		return p
+	}
+
+	p = s.newSource(addr)
+	s.sources[addr] = p
+
+	return p
+}
+
+// sentinelConn is a connection to the master that refreshes the master when
+// it fails
+type sentinelConn struct {
+	Conn
+	s *Sentinel
+}
+
+func (c *sentinelConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+	c.check(err)
+
+	return reply, err
+}
+
+func (c *sentinelConn) Pipeline(cmds []Command) ([]interface{}, error) {
+	replies, err := c.Conn.Pipeline(cmds)
+	c.check(err)
+
+	for _, r := range replies {
+		if e, ok := r.(ReplyError); ok {
+			c.check(e)
+		}
+	}
+
+	return replies, err
+}
+
+func (c *sentinelConn) check(err error) {
+	if err == nil {
+		return
+	}
+
+	if e, ok := err.(ReplyError); ok && !strings.HasPrefix(string(e),
+		"READONLY") {
+
+		return
+	}
+
+	c.s.failed()
+}
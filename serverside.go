@@ -0,0 +1,114 @@
+package autocomplete
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SetServerSideSearch enables or disables running prefixes indexing searches
+// in a single server side script, which intersects the word prefixes, ranges
+// over the intersection up to the search limit and fetches the documents in
+// one round trip. the script is loaded when server side search is enabled.
+//
+// searches with options that rank results on the client, SortRelevance,
+// decaying scores or spelling retries run on the client.
+func (a *Autocomplete) SetServerSideSearch(enabled bool) error {
+	a.serverSide = enabled
+	if !enabled {
+		return nil
+	}
+
+	return a.loadScripts(context.Background(), "search")
+}
+
+// serverSideSearch returns whether a search runs in the server side script
+func (a *Autocomplete) serverSideSearch(index string,
+	opts SearchOptions) (bool, error) {
+
+	if !a.serverSide || opts.Phonetic || opts.Phrase || opts.InOrderBoost ||
+		opts.ClickBoost || opts.User != "" || opts.SpellRetry {
+
+		return false, nil
+	}
+
+	switch opts.Sort {
+	case SortLexicographical, SortRevLexicographical:
+
+	case SortScore, SortRevScore:
+		if _, ok := a.halfLives[index]; ok {
+			return false, nil
+		}
+
+	default:
+		return false, nil
+	}
+
+	l, err := a.layout(index)
+	if err != nil {
+		return false, err
+	}
+
+	return l.indexType == PrefixesIndexing, nil
+}
+
+// scriptSearch runs a prefixes indexing search in the server side script
+func (a *Autocomplete) scriptSearch(index, query string,
+	opts SearchOptions) ([][]byte, error) {
+
+	terms := a.analyze(query)
+	if len(terms) == 0 {
+		return [][]byte{}, nil
+	}
+
+	keys := []string{}
+	for _, t := range terms {
+		keys = append(keys, a.prefixKey(index, t))
+	}
+
+	conn := a.intersectionConn(keys)
+	defer conn.Close()
+
+	zkey := keys[0]
+	previous := zkey
+	if len(keys) > 1 {
+		zkey = a.indexKey(intersectionKind, index, terms...)
+		previous = zkey
+		if p := previousTerms(terms); len(p) > 1 {
+			previous = a.indexKey(intersectionKind, index, p...)
+		}
+	}
+
+	order := map[int]string{
+		SortLexicographical:    "lex",
+		SortRevLexicographical: "revlex",
+		SortScore:              "score",
+		SortRevScore:           "revscore",
+	}[opts.Sort]
+
+	args := []interface{}{len(keys) + 5, zkey, a.intersectionsKey(index),
+		previous, a.documentsKey(index), a.expiryKey(index)}
+	for _, k := range keys {
+		args = append(args, k)
+	}
+
+	args = append(args, a.intersectionMillis(), order, opts.Limit,
+		time.Now().Unix())
+
+	values, err := replyValues(a.runScript(conn, "search", args...))
+	if err != nil {
+		return [][]byte{}, err
+	}
+
+	results := [][]byte{}
+	for _, v := range values {
+		b, ok := v.([]byte)
+		if !ok {
+			return [][]byte{}, fmt.Errorf("type assertion error")
+		}
+
+		results = append(results, b)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,661 @@
+package autocomplete
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shardReplicas is the number of points of every shard on the consistent
+// hashing ring
+const shardReplicas = 160
+
+// Sharded is an autocomplete service that distributes the documents of its
+// indexes across shards by consistent hashing of the document keys, every
+// shard is an Autocomplete service on its own connection source.
+//
+// shards are identified by their position, every process must be given the
+// same shards in the same order and new shards are appended to the end.
+// writes go to the shard that owns the document and searches query all of
+// the shards and merge their results.
+type Sharded struct {
+	shards []*Autocomplete
+	ring   []shardPoint
+}
+
+type shardPoint struct {
+	hash  uint32
+	shard int
+}
+
+// NewSharded creates a sharded autocomplete service with a shard for every
+// connection source, the services of the shards are configured by the given
+// options. it returns an error when there are no connection sources or one of
+// them is nil.
+func NewSharded(pools []ConnSource, prefix string, indexType int,
+	opts ...Option) (*Sharded, error) {
+
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("no shards")
+	}
+
+	s := &Sharded{}
+
+	for i, pool := range pools {
+		if pool == nil {
+			return nil, fmt.Errorf("nil connection source of shard %d", i)
+		}
+
+		a, err := New(pool, prefix, indexType, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		s.shards = append(s.shards, a)
+
+		for r := 0; r < shardReplicas; r++ {
+			point := strconv.Itoa(i) + "-" + strconv.Itoa(r)
+			s.ring = append(s.ring, shardPoint{
+				hash:  crc32.ChecksumIEEE([]byte(point)),
+				shard: i,
+			})
+		}
+	}
+
+	sort.Sort(byHash(s.ring))
+
+	return s, nil
+}
+
+// Shards returns the services of the shards, all of them should be configured
+// the same way before the sharded service is used
+func (s *Sharded) Shards() []*Autocomplete {
+	return s.shards
+}
+
+// shardOf returns the position of the shard that owns a document key, which
+// is the first point on the ring at or after the hash of the key
+func (s *Sharded) shardOf(docKey string) int {
+	h := crc32.ChecksumIEEE([]byte(docKey))
+
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= h
+	})
+
+	if i == len(s.ring) {
+		i = 0
+	}
+
+	return s.ring[i].shard
+}
+
+// routingKey returns the key that routes a stored document to its shard,
+// documents are routed by their legacy keys in every key schema
+func routingKey(docKeys int, docKey string) string {
+	parts := strings.SplitN(docKey, ":", 2)
+	if docKeys == KeySchemaLegacy || len(parts) != 2 {
+		return docKey
+	}
+
+	return buildDocKey(KeySchemaLegacy, keyUnescaper.Replace(parts[0]),
+		keyUnescaper.Replace(parts[1]))
+}
+
+func (s *Sharded) shard(d Document) *Autocomplete {
+	return s.shards[s.shardOf(key(d))]
+}
+
+// Index indexes a document on the shard that owns it
+func (s *Sharded) Index(index string, d Document, score uint64) error {
+	return s.shard(d).Index(index, d, score)
+}
+
+// IndexWithExpiry indexes a document on the shard that owns it until the
+// given expiry time
+func (s *Sharded) IndexWithExpiry(index string, d Document, score uint64,
+	expiresAt time.Time) error {
+
+	return s.shard(d).IndexWithExpiry(index, d, score, expiresAt)
+}
+
+// RemoveDocument removes a document from the shard that owns it
+func (s *Sharded) RemoveDocument(index string, d Document) error {
+	return s.shard(d).RemoveDocument(index, d)
+}
+
+// UpdateDocument updates a document on the shard that owns it
+func (s *Sharded) UpdateDocument(index string, d Document) error {
+	return s.shard(d).UpdateDocument(index, d)
+}
+
+// UpdateScore updates the score of a document on the shard that owns it
+func (s *Sharded) UpdateScore(index string, d Document, score uint64) error {
+	return s.shard(d).UpdateScore(index, d, score)
+}
+
+// Upsert indexes a document on the shard that owns it, replacing a document
+// with the same ID on that shard, and removes documents with the same ID from
+// the other shards, which own it under its previous term. the document keeps
+// the expiry of the document it replaces.
+//
+// the replacement is atomic on the owner only, searches between the writes
+// may return both documents.
+func (s *Sharded) Upsert(index string, d Document, score uint64) error {
+	owner := s.shardOf(key(d))
+
+	expiresAt := time.Time{}
+	for i, a := range s.shards {
+		if i == owner {
+			continue
+		}
+
+		e, err := a.expiryByID(index, d.ID())
+		if err != nil {
+			return err
+		}
+
+		if !e.IsZero() {
+			expiresAt = e
+		}
+	}
+
+	if _, err := s.shards[owner].replaceByID(index, d.ID(), d, score,
+		expiresAt); err != nil {
+
+		return err
+	}
+
+	for i, a := range s.shards {
+		if i == owner {
+			continue
+		}
+
+		if _, err := a.replaceByID(index, d.ID(), nil, 0,
+			time.Time{}); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveByID removes the document with the given ID from every shard, the
+// shard that owns it is not known without its term. it returns the not found
+// error of the first shard when no shard has the document.
+func (s *Sharded) RemoveByID(index, id string) error {
+	found := false
+	var notFound error
+
+	for _, a := range s.shards {
+		err := a.RemoveByID(index, id)
+		if err == nil {
+			found = true
+			continue
+		}
+
+		if !errors.Is(err, ErrDocumentNotFound) &&
+			!errors.Is(err, ErrIndexNotFound) {
+
+			return err
+		}
+
+		if notFound == nil {
+			notFound = err
+		}
+	}
+
+	if found {
+		return nil
+	}
+
+	return notFound
+}
+
+// RecordSelection records the selection of a document on the shard that owns
+// it
+func (s *Sharded) RecordSelection(index, query string, d Document) error {
+	return s.shard(d).RecordSelection(index, query, d)
+}
+
+// RecordUserSelection records a user's selection of a document on the shard
+// that owns it
+func (s *Sharded) RecordUserSelection(index, user string, d Document) error {
+	return s.shard(d).RecordUserSelection(index, user, d)
+}
+
+// Touch records activity of a document on the shard that owns it
+func (s *Sharded) Touch(index string, d Document, at time.Time) error {
+	return s.shard(d).Touch(index, d, at)
+}
+
+// Search searches all of the shards and merges their results in the given
+// sort order
+func (s *Sharded) Search(index, query string, sort int) ([][]byte, error) {
+	return s.SearchWithOptions(index, query, SearchOptions{Sort: sort})
+}
+
+// SearchWithOptions searches all of the shards concurrently and merges their
+// results in the requested sort order.
+//
+// results are merged by the signals they are ranked by, relevance is computed
+// with the highest score of all shards and lexicographical results are merged
+// by document key. a spelling correction is searched only when no shard
+// matches the query.
+func (s *Sharded) SearchWithOptions(index, query string,
+	opts SearchOptions) ([][]byte, error) {
+
+	shards := make([]*Autocomplete, len(s.shards))
+	for i, a := range s.shards {
+		if opts.ReadYourWrites {
+			shards[i] = a.primary()
+		} else {
+			shards[i] = a.searchService()
+		}
+	}
+
+	matched, err := matchShards(shards, index, query, opts.Sort)
+	if err != nil {
+		return [][]byte{}, err
+	}
+
+	if total(matched) == 0 && opts.SpellRetry {
+		suggestion, err := spellSuggest(shards, index, query)
+		if err != nil {
+			return [][]byte{}, err
+		}
+
+		if suggestion != "" {
+			query = suggestion
+
+			matched, err = matchShards(shards, index, query, opts.Sort)
+			if err != nil {
+				return [][]byte{}, err
+			}
+		}
+	}
+
+	scores := make([][]float64, len(shards))
+
+	switch opts.Sort {
+	case SortScore, SortRevScore, SortRelevance:
+		err := each(shards, func(i int, a *Autocomplete) error {
+			var err error
+			scores[i], err = a.decayedScores(index, matched[i])
+
+			return err
+		})
+		if err != nil {
+			return [][]byte{}, err
+		}
+	}
+
+	max := 0.0
+	for _, values := range scores {
+		if m := maxScore(values); m > max {
+			max = m
+		}
+	}
+
+	ranked := make([][]rankedKey, len(shards))
+
+	err = each(shards, func(i int, a *Autocomplete) error {
+		var err error
+		ranked[i], err = a.rankKeys(index, query, opts, matched[i], scores[i],
+			max)
+
+		return err
+	})
+	if err != nil {
+		return [][]byte{}, err
+	}
+
+	keys, owners := mergeRanked(ranked, opts.Sort)
+	if opts.Limit > 0 && len(keys) > opts.Limit {
+		keys = keys[:opts.Limit]
+		owners = owners[:opts.Limit]
+	}
+
+	docs, err := fetchShards(shards, index, keys, owners)
+	if err != nil {
+		return [][]byte{}, err
+	}
+
+	if s.shards[0].queryRetention > 0 {
+		if err := s.shards[0].RecordQuery(index, query); err != nil {
+			return [][]byte{}, err
+		}
+	}
+
+	return docs, nil
+}
+
+// SpellSuggest returns the spelling correction of a query that is suggested
+// by the most shards, ties are broken by the order of the shards
+func (s *Sharded) SpellSuggest(index, query string) (string, error) {
+	return spellSuggest(s.shards, index, query)
+}
+
+func spellSuggest(shards []*Autocomplete, index,
+	query string) (string, error) {
+
+	suggestions := make([]string, len(shards))
+
+	err := each(shards, func(i int, a *Autocomplete) error {
+		var err error
+		suggestions[i], err = a.SpellSuggest(index, query)
+
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	counts := make(map[string]int)
+	suggestion := ""
+	for _, c := range suggestions {
+		if c == "" {
+			continue
+		}
+
+		counts[c]++
+		if counts[c] > counts[suggestion] {
+			suggestion = c
+		}
+	}
+
+	return suggestion, nil
+}
+
+// matchShards returns the document keys of every shard that match a query
+// literally
+func matchShards(shards []*Autocomplete, index, query string,
+	orderBy int) ([][]string, error) {
+
+	matched := make([][]string, len(shards))
+
+	err := each(shards, func(i int, a *Autocomplete) error {
+		var err error
+		matched[i], err = a.matchKeys(index, query, orderBy)
+
+		return err
+	})
+
+	return matched, err
+}
+
+func total(matched [][]string) int {
+	n := 0
+	for _, keys := range matched {
+		n += len(keys)
+	}
+
+	return n
+}
+
+// mergeRanked merges the ranked keys of the shards in the order of their
+// results and returns the keys with the shards they were found on, documents
+// found on more than one shard during resharding are returned once
+func mergeRanked(ranked [][]rankedKey, orderBy int) ([]string, []int) {
+	all := []rankedKey{}
+	shardOf := make(map[string]int)
+
+	for i, keys := range ranked {
+		for _, r := range keys {
+			if _, ok := shardOf[r.key]; ok {
+				continue
+			}
+
+			shardOf[r.key] = i
+			all = append(all, r)
+		}
+	}
+
+	sortRanked(all, orderBy, true)
+
+	keys := []string{}
+	owners := []int{}
+	for _, r := range all {
+		keys = append(keys, r.key)
+		owners = append(owners, shardOf[r.key])
+	}
+
+	return keys, owners
+}
+
+// fetchShards fetches the documents of the given keys from the shards they
+// were found on, documents removed since they were found are skipped
+func fetchShards(shards []*Autocomplete, index string, keys []string,
+	owners []int) ([][]byte, error) {
+
+	shardKeys := make([][]string, len(shards))
+	for i, k := range keys {
+		shardKeys[owners[i]] = append(shardKeys[owners[i]], k)
+	}
+
+	docs := make([]map[string][]byte, len(shards))
+
+	err := each(shards, func(i int, a *Autocomplete) error {
+		docs[i] = make(map[string][]byte)
+		if len(shardKeys[i]) == 0 {
+			return nil
+		}
+
+		values, err := a.hmget(a.documentsKey(index), shardKeys[i])
+		if err != nil {
+			return err
+		}
+
+		for j, v := range values {
+			if v == nil {
+				continue
+			}
+
+			b, ok := v.([]byte)
+			if !ok {
+				return fmt.Errorf("type assertion error")
+			}
+
+			docs[i][shardKeys[i][j]] = b
+		}
+
+		return nil
+	})
+	if err != nil {
+		return [][]byte{}, err
+	}
+
+	results := [][]byte{}
+	for i, k := range keys {
+		if b, ok := docs[owners[i]][k]; ok {
+			results = append(results, b)
+		}
+	}
+
+	return results, nil
+}
+
+// each calls fn concurrently for every shard and returns the first error
+func each(shards []*Autocomplete, fn func(i int, a *Autocomplete) error) error {
+	var wg sync.WaitGroup
+	e := make(chan error, len(shards))
+
+	for i, a := range shards {
+		wg.Add(1)
+		go func(i int, a *Autocomplete) {
+			defer wg.Done()
+
+			if err := fn(i, a); err != nil {
+				e <- err
+			}
+		}(i, a)
+	}
+
+	wg.Wait()
+	close(e)
+
+	return <-e
+}
+
+// Reshard moves the documents of an index that are stored on a shard which
+// does not own them to their owners, it should be called for every index
+// after shards are added. it returns the number of moved documents.
+//
+// documents are moved with their data, score and expiry, their activity and
+// selections are not moved. documents indexed without their original term can
+// not be moved and are left in place. searches during resharding return moved
+// documents once.
+func (s *Sharded) Reshard(index string) (int, error) {
+	moved := 0
+
+	for i, a := range s.shards {
+		n, err := s.reshard(index, i, a)
+		moved += n
+
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+func (s *Sharded) reshard(index string, from int, a *Autocomplete) (int, error) {
+	l, err := a.layout(index)
+	if err != nil {
+		return 0, err
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	moved := 0
+
+	err = scan(conn, "HSCAN", a.documentsKey(index), "",
+		func(values []string) error {
+			keys := []string{}
+			data := []string{}
+			for i := 0; i < len(values); i += 2 {
+				if s.shardOf(routingKey(l.docKeys, values[i])) != from {
+					keys = append(keys, values[i])
+					data = append(data, values[i+1])
+				}
+			}
+
+			if len(keys) == 0 {
+				return nil
+			}
+
+			terms, err := a.primary().storedTerms(index, keys)
+			if err != nil {
+				return err
+			}
+
+			scores, err := a.layoutScores(conn, index, l.indexType, keys, terms)
+			if err != nil {
+				return err
+			}
+
+			for i, docKey := range keys {
+				id, ok := keyID(l.docKeys, docKey, terms[i])
+				if !ok || scores[i] == nil {
+					continue
+				}
+
+				expiresAt, err := a.expiresAt(conn, index, docKey)
+				if err != nil {
+					return err
+				}
+
+				d := storedDocument{
+					id:   id,
+					term: terms[i],
+					data: json.RawMessage(data[i]),
+				}
+
+				// a document that was copied by an interrupted
+				// resharding is only removed
+				owner := s.shards[s.shardOf(key(d))]
+				ol, err := owner.layout(index)
+				if err != nil {
+					return err
+				}
+
+				exists, err := owner.exists(index, ol.docKey(d))
+				if err != nil {
+					return err
+				}
+
+				if !exists {
+					if err := owner.IndexWithExpiry(index, d, *scores[i],
+						expiresAt); err != nil {
+
+						return err
+					}
+				}
+
+				if err := a.removeKey(index, docKey, terms[i]); err != nil {
+					return err
+				}
+
+				moved++
+			}
+
+			return nil
+		})
+
+	return moved, err
+}
+
+// exists checks if a document key is stored in an index
+func (a *Autocomplete) exists(index, docKey string) (bool, error) {
+	conn := a.conn()
+	defer conn.Close()
+
+	return replyBool(conn.Do("HEXISTS", a.documentsKey(index),
+		docKey))
+}
+
+// storedDocument is a document restored from its stored term and JSON data,
+// it is stored again with the same data
+type storedDocument struct {
+	id   string
+	term string
+	data json.RawMessage
+}
+
+func (d storedDocument) ID() string {
+	return d.id
+}
+
+func (d storedDocument) Term() string {
+	return d.term
+}
+
+func (d storedDocument) Data() interface{} {
+	return d.data
+}
+
+func (d storedDocument) MarshalJSON() ([]byte, error) {
+	return d.data, nil
+}
+
+type byHash []shardPoint
+
+func (p byHash) Len() int {
+	return len(p)
+}
+
+func (p byHash) Less(i, j int) bool {
+	return p[i].hash < p[j].hash
+}
+
+func (p byHash) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
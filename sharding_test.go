@@ -0,0 +1,97 @@
+package autocomplete
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestShardOf(t *testing.T) {
+	s, err := NewSharded([]ConnSource{&fakeSource{}, &fakeSource{}}, "ac",
+		PrefixesIndexing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grown, err := NewSharded([]ConnSource{&fakeSource{}, &fakeSource{},
+		&fakeSource{}}, "ac", PrefixesIndexing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make([]int, 3)
+	for i := 0; i < 3000; i++ {
+		docKey := "term_" + strconv.Itoa(i)
+
+		if s.shardOf(docKey) != s.shardOf(docKey) {
+			t.Fatalf("%s is not owned by the same shard", docKey)
+		}
+
+		// keys only move to the added shard
+		owner := grown.shardOf(docKey)
+		if owner != 2 && owner != s.shardOf(docKey) {
+			t.Fatalf("%s moved from shard %d to shard %d", docKey,
+				s.shardOf(docKey), owner)
+		}
+
+		counts[owner]++
+	}
+
+	for i, c := range counts {
+		if c < 500 {
+			t.Fatalf("shard %d owns %d of 3000 keys", i, c)
+		}
+	}
+}
+
+func TestNewSharded(t *testing.T) {
+	if _, err := NewSharded([]ConnSource{}, "ac", PrefixesIndexing); err == nil {
+		t.Fatal("expected an error for no shards")
+	}
+
+	if _, err := NewSharded([]ConnSource{&fakeSource{}, nil}, "ac",
+		PrefixesIndexing); err == nil {
+
+		t.Fatal("expected an error for a nil connection source")
+	}
+}
+
+func TestMergeRanked(t *testing.T) {
+	ranked := [][]rankedKey{
+		{
+			{key: "a_1", value: 5},
+			{key: "c_3", value: 3},
+			{key: "c_4", value: 1},
+			{key: "f_6", value: 9, phonetic: true},
+		},
+		{
+			{key: "e_5", value: 0.5, clicks: 2},
+			{key: "b_2", value: 4},
+			{key: "c_3", value: 3},
+		},
+	}
+
+	tests := []struct {
+		orderBy int
+		keys    []string
+		owners  []int
+	}{
+		{SortRevScore, []string{"e_5", "a_1", "b_2", "c_3", "c_4", "f_6"},
+			[]int{1, 0, 1, 0, 0, 0}},
+		{SortLexicographical, []string{"e_5", "a_1", "b_2", "c_3", "c_4",
+			"f_6"}, []int{1, 0, 1, 0, 0, 0}},
+		{SortScore, []string{"e_5", "c_4", "c_3", "b_2", "a_1", "f_6"},
+			[]int{1, 0, 0, 1, 0, 0}},
+	}
+
+	for _, test := range tests {
+		keys, owners := mergeRanked(ranked, test.orderBy)
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Fatalf("expected %v, got %v", test.keys, keys)
+		}
+
+		if !reflect.DeepEqual(owners, test.owners) {
+			t.Fatalf("expected shards %v, got %v", test.owners, owners)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package autocomplete
+
+import (
+	"strings"
+)
+
+// spellingAlphabet are the characters inserted and replaced when generating
+// spelling corrections
+const spellingAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// SpellSuggest returns the closest corrected query that matches indexed
+// documents, or an empty string when the query matches as is or no
+// correction is found.
+//
+// in prefixes indexing every query word is corrected separately against the
+// vocabulary of indexed word prefixes, in terms indexing the entire query is
+// corrected against the indexed term prefixes. corrections are at most one
+// edit away from the original and are ranked by the number of documents they
+// match.
+func (a *Autocomplete) SpellSuggest(index, query string) (string, error) {
+	words := a.analyze(query)
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	l, err := a.layout(index)
+	if err != nil {
+		return "", err
+	}
+
+	conn := a.readConn()
+	defer conn.Close()
+
+	corrected := []string{}
+
+	switch l.indexType {
+	case PrefixesIndexing:
+		for _, w := range words {
+			c, err := closest(conn, w, "ZCARD", func(c string) []interface{} {
+				return []interface{}{a.prefixKey(index, c)}
+			})
+			if err != nil {
+				return "", err
+			}
+
+			if c == "" {
+				return "", nil
+			}
+
+			corrected = append(corrected, c)
+		}
+
+	case TermsIndexing:
+		zkey := a.termsIndexKey(index)
+		c, err := closest(conn, strings.Join(words, " "), "ZLEXCOUNT",
+			func(c string) []interface{} {
+				min, max := a.prefixRange(c)
+				return []interface{}{zkey, min, max}
+			})
+		if err != nil {
+			return "", err
+		}
+
+		if c == "" {
+			return "", nil
+		}
+
+		corrected = append(corrected, c)
+
+	default:
+		return "", ErrInvalidIndexType
+	}
+
+	suggestion := strings.Join(corrected, " ")
+	if suggestion == strings.Join(words, " ") {
+		return "", nil
+	}
+
+	return suggestion, nil
+}
+
+// closest returns the word if it matches any document, otherwise the edit of
+// the word that matches the most documents, or an empty string if none does.
+//
+// the number of documents matched by a candidate is the integer reply of cmd
+// invoked with args(candidate), the commands are pipelined.
+func closest(conn *redisConn, word, cmd string,
+	args func(string) []interface{}) (string, error) {
+
+	candidates := append([]string{word}, edits(word)...)
+	for _, c := range candidates {
+		if err := conn.Send(cmd, args(c)...); err != nil {
+			return "", err
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return "", err
+	}
+
+	counts := []int{}
+	for range candidates {
+		n, err := replyInt(conn.Receive())
+		if err != nil {
+			return "", err
+		}
+
+		counts = append(counts, n)
+	}
+
+	if counts[0] > 0 {
+		return word, nil
+	}
+
+	// deletions are only used when no other edit matches, because a word
+	// prefix matches at least as many documents as the word itself. equally
+	// matching edits are ranked by length, so a completed word is preferred
+	// over its prefix.
+	best := ""
+	max := 0
+	shorter := false
+	for i, c := range candidates {
+		if counts[i] == 0 {
+			continue
+		}
+
+		s := len(c) < len(word)
+		switch {
+		case best == "",
+			shorter && !s,
+			shorter == s && counts[i] > max,
+			shorter == s && counts[i] == max && len(c) > len(best):
+
+			best = c
+			max = counts[i]
+			shorter = s
+		}
+	}
+
+	return best, nil
+}
+
+// edits returns the unique strings that are one deletion, transposition,
+// replacement or insertion away from the word
+func edits(word string) []string {
+	w := []rune(word)
+	seen := map[string]bool{word: true}
+	results := []string{}
+
+	add := func(r []rune) {
+		s := string(r)
+		if !seen[s] {
+			seen[s] = true
+			results = append(results, s)
+		}
+	}
+
+	for i := range w {
+		add(append(append([]rune{}, w[:i]...), w[i+1:]...))
+	}
+
+	for i := 0; i < len(w)-1; i++ {
+		t := append([]rune{}, w...)
+		t[i], t[i+1] = t[i+1], t[i]
+		add(t)
+	}
+
+	for i := range w {
+		for _, c := range spellingAlphabet {
+			t := append([]rune{}, w...)
+			t[i] = c
+			add(t)
+		}
+	}
+
+	for i := 0; i <= len(w); i++ {
+		for _, c := range spellingAlphabet {
+			t := append(append(append([]rune{}, w[:i]...), c), w[i:]...)
+			add(t)
+		}
+	}
+
+	return results
+}
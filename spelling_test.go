@@ -0,0 +1,34 @@
+package autocomplete
+
+import "testing"
+
+func TestEdits(t *testing.T) {
+	e := edits("ab")
+
+	// 2 deletions, 1 transposition, 2*36 replacements and 3*36 insertions
+	// minus the duplicates
+	if len(e) == 0 || len(e) > 2+1+2*36+3*36 {
+		t.Fatalf("unexpected number of edits %d", len(e))
+	}
+
+	expected := []string{"a", "b", "ba", "xb", "axb", "abx", "xab"}
+	for _, x := range expected {
+		found := false
+		for _, s := range e {
+			if s == x {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Errorf("%q is not an edit of \"ab\"", x)
+		}
+	}
+
+	for _, s := range e {
+		if s == "ab" {
+			t.Error("the word itself is not an edit")
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package autocomplete
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxUpsertRetries is the number of times an upsert is retried when the
+// indexed document is concurrently modified
+const maxUpsertRetries = 10
+
+// idsKey is the key of the hash that maps document IDs to document keys
+func (a *Autocomplete) idsKey(index string) string {
+	return a.indexKey(idsKind, index)
+}
+
+// Upsert indexes a document identified by its ID alone, a document with the
+// same ID that is already indexed is replaced atomically even if its term
+// changed, so its stale prefixes are removed. the document keeps the expiry
+// of the document it replaces.
+//
+// only documents indexed since document IDs are tracked can be replaced.
+func (a *Autocomplete) Upsert(index string, d Document, score uint64) error {
+	_, err := a.replaceByID(index, d.ID(), d, score, time.Time{})
+	return err
+}
+
+// RemoveByID removes the document with the given ID from the autocomplete
+// search index.
+//
+// only documents indexed since document IDs are tracked can be removed by
+// their ID.
+func (a *Autocomplete) RemoveByID(index, id string) error {
+	found, err := a.replaceByID(index, id, nil, 0, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		conn := a.conn()
+		defer conn.Close()
+
+		return a.notFound(conn, index, id, nil)
+	}
+
+	return nil
+}
+
+// replaceByID atomically removes the indexed document with the given ID and
+// indexes d in its place if it is not nil, d keeps the expiry of the removed
+// document or expires at expiresAt when no document is removed. it returns
+// whether a document with the ID was indexed.
+func (a *Autocomplete) replaceByID(index, id string, d Document,
+	score uint64, expiresAt time.Time) (bool, error) {
+
+	l, err := a.layout(index)
+	if err != nil {
+		return false, err
+	}
+
+	if l.indexType != PrefixesIndexing && l.indexType != TermsIndexing {
+		return false, ErrInvalidIndexType
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	if d != nil {
+		if err := a.recordKeySchema(conn); err != nil {
+			return false, err
+		}
+	}
+
+	for i := 0; i < maxUpsertRetries; i++ {
+		if _, err := conn.Do("WATCH", a.idsKey(index), a.documentsKey(index),
+			a.termsIndexKey(index), a.expiryKey(index)); err != nil {
+
+			return false, err
+		}
+
+		docKey, term, member, err := a.indexedByID(conn, l, index, id)
+		if err != nil {
+			a.unwatch(conn)
+			return false, err
+		}
+
+		if docKey == "" && d == nil {
+			a.unwatch(conn)
+			return false, nil
+		}
+
+		expiry := expiresAt
+		if docKey != "" {
+			expiry, err = a.expiresAt(conn, index, docKey)
+			if err != nil {
+				a.unwatch(conn)
+				return false, err
+			}
+		}
+
+		if err := conn.Send("MULTI"); err != nil {
+			return false, err
+		}
+
+		if docKey != "" {
+			if err := a.sendRemove(conn, l, index, docKey, term,
+				member); err != nil {
+
+				return false, err
+			}
+		}
+
+		if d != nil {
+			if err := a.sendIndex(conn, l, index, d, score,
+				expiry); err != nil {
+
+				return false, err
+			}
+		}
+
+		reply, err := conn.Do("EXEC")
+		if err := replyError(reply, err); err != nil {
+			return false, err
+		}
+
+		// a nil reply means the transaction was aborted by the watch
+		if reply != nil {
+			return docKey != "", a.invalidate(conn, index)
+		}
+	}
+
+	return false, fmt.Errorf("%s was concurrently modified %d times", id,
+		maxUpsertRetries)
+}
+
+// expiryByID returns the expiry time of the indexed document with the given
+// ID, or the zero time when it is not indexed or does not expire
+func (a *Autocomplete) expiryByID(index, id string) (time.Time, error) {
+	conn := a.conn()
+	defer conn.Close()
+
+	docKey, err := replyString(conn.Do("HGET", a.idsKey(index), id))
+	if err == errNil {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+
+	return a.expiresAt(conn, index, docKey)
+}
+
+// indexedByID returns the key, term and terms indexing member of the indexed
+// document with the given ID, the key is empty if no such document is indexed
+func (a *Autocomplete) indexedByID(conn *redisConn, l layout, index,
+	id string) (string, string, string, error) {
+
+	docKey, err := replyString(conn.Do("HGET", a.idsKey(index), id))
+	if err == errNil {
+		return "", "", "", nil
+	} else if err != nil {
+		return "", "", "", err
+	}
+
+	term, err := replyString(conn.Do("HGET", a.termsKey(index), docKey))
+	if err == errNil {
+		term = keyTerm(l.docKeys, docKey)
+	} else if err != nil {
+		return "", "", "", err
+	}
+
+	member, err := a.termsMember(conn, l.indexType, index, docKey)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return docKey, term, member, nil
+}
@@ -0,0 +1,530 @@
+package autocomplete
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scanCount is the COUNT hint of the SCAN family commands used to verify an
+// index incrementally
+const scanCount = 1000
+
+// verifyBatch is the number of documents whose ZSET memberships are checked in
+// a single pipeline
+const verifyBatch = 100
+
+// VerifyReport counts the inconsistencies found in an index, for a repair the
+// counts are the number of fixed inconsistencies
+type VerifyReport struct {
+	// Documents is the number of stored documents
+	Documents int
+
+	// Unverified is the number of documents indexed without their original
+	// term, their ZSET memberships can not be verified
+	Unverified int
+
+	// OrphanMembers is the number of ZSET members of documents that are not
+	// stored
+	OrphanMembers int
+
+	// OrphanEntries is the number of term, ID, activity and expiry entries of
+	// documents that are not stored
+	OrphanEntries int
+
+	// MissingPrefixes is the number of ZSETs that stored documents are
+	// missing from, including the terms indexing ZSET
+	MissingPrefixes int
+
+	// MismatchedScores is the number of documents with different scores in
+	// their ZSETs
+	MismatchedScores int
+}
+
+// Verify scans an index incrementally and reports its inconsistencies
+func (a *Autocomplete) Verify(index string) (VerifyReport, error) {
+	return a.verify(index, false)
+}
+
+// Repair scans an index incrementally and fixes its inconsistencies, orphans
+// are removed and documents are added to their missing ZSETs with their
+// highest score
+func (a *Autocomplete) Repair(index string) (VerifyReport, error) {
+	return a.verify(index, true)
+}
+
+func (a *Autocomplete) verify(index string, repair bool) (VerifyReport, error) {
+	r := VerifyReport{}
+
+	l, err := a.layout(index)
+	if err != nil {
+		return r, err
+	}
+
+	if l.indexType != PrefixesIndexing && l.indexType != TermsIndexing {
+		return r, ErrInvalidIndexType
+	}
+
+	conn := a.conn()
+	defer conn.Close()
+
+	// documents and their stored terms
+	docs := make(map[string]bool)
+	if err := scan(conn, "HSCAN", a.documentsKey(index), "",
+		func(values []string) error {
+			for i := 0; i < len(values); i += 2 {
+				docs[values[i]] = true
+			}
+
+			return nil
+		}); err != nil {
+
+		return r, err
+	}
+
+	r.Documents = len(docs)
+
+	terms := make(map[string]string)
+	if err := scan(conn, "HSCAN", a.termsKey(index), "",
+		func(values []string) error {
+			for i := 0; i < len(values); i += 2 {
+				if docs[values[i]] {
+					terms[values[i]] = values[i+1]
+				}
+			}
+
+			return nil
+		}); err != nil {
+
+		return r, err
+	}
+
+	r.Unverified = len(docs) - len(terms)
+
+	if err := a.verifyEntries(conn, index, docs, repair, &r); err != nil {
+		return r, err
+	}
+
+	zsets := []string{}
+	if l.indexType == PrefixesIndexing {
+		zsets = append(zsets, a.prefixKey(index, ""))
+	}
+
+	if a.phonetic {
+		zsets = append(zsets, a.phoneticKey(index, ""))
+	}
+
+	for _, p := range zsets {
+		if err := scanKeys(conn, p, func(keys []string) error {
+			for _, k := range keys {
+				if err := a.verifyOrphans(conn, index, k, docs, repair,
+					&r); err != nil {
+
+					return err
+				}
+			}
+
+			return nil
+		}); err != nil {
+
+			return r, err
+		}
+	}
+
+	members := make(map[string][]string)
+	if l.indexType == TermsIndexing {
+		zkey := a.termsIndexKey(index)
+		if err := scan(conn, "ZSCAN", zkey, "", func(values []string) error {
+			orphans := []string{}
+			for i := 0; i < len(values); i += 2 {
+				// members that can not be decoded are orphans
+				_, _, docKey, err := a.decodeMember(values[i])
+				if err == nil && docs[docKey] {
+					members[docKey] = append(members[docKey], values[i])
+					continue
+				}
+
+				orphans = append(orphans, values[i], docKey)
+			}
+
+			n, err := a.removeOrphans(conn, index, "ZREM", zkey, orphans,
+				repair)
+			r.OrphanMembers += n
+
+			return err
+		}); err != nil {
+
+			return r, err
+		}
+	}
+
+	batch := []string{}
+	for docKey := range terms {
+		batch = append(batch, docKey)
+		if len(batch) < verifyBatch {
+			continue
+		}
+
+		if err := a.verifyDocuments(conn, l.indexType, index, batch, terms,
+			members, repair, &r); err != nil {
+
+			return r, err
+		}
+
+		batch = []string{}
+	}
+
+	if err := a.verifyDocuments(conn, l.indexType, index, batch, terms,
+		members, repair, &r); err != nil {
+
+		return r, err
+	}
+
+	if repair {
+		if err := a.invalidate(conn, index); err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// verifyEntries counts and optionally removes the term, ID, activity and
+// expiry entries of documents that are not stored
+func (a *Autocomplete) verifyEntries(conn *redisConn, index string,
+	docs map[string]bool, repair bool, r *VerifyReport) error {
+
+	// hashes keyed by document keys
+	for _, hkey := range []string{a.termsKey(index), a.activityKey(index)} {
+		if err := scan(conn, "HSCAN", hkey, "", func(values []string) error {
+			orphans := []string{}
+			for i := 0; i < len(values); i += 2 {
+				if !docs[values[i]] {
+					orphans = append(orphans, values[i], values[i])
+				}
+			}
+
+			n, err := a.removeOrphans(conn, index, "HDEL", hkey, orphans,
+				repair)
+			r.OrphanEntries += n
+
+			return err
+		}); err != nil {
+
+			return err
+		}
+	}
+
+	ikey := a.idsKey(index)
+	if err := scan(conn, "HSCAN", ikey, "", func(values []string) error {
+		orphans := []string{}
+		for i := 0; i < len(values); i += 2 {
+			if !docs[values[i+1]] {
+				orphans = append(orphans, values[i], values[i+1])
+			}
+		}
+
+		n, err := a.removeOrphans(conn, index, "HDEL", ikey, orphans, repair)
+		r.OrphanEntries += n
+
+		return err
+	}); err != nil {
+
+		return err
+	}
+
+	ekey := a.expiryKey(index)
+	return scan(conn, "ZSCAN", ekey, "", func(values []string) error {
+		orphans := []string{}
+		for i := 0; i < len(values); i += 2 {
+			if !docs[values[i]] {
+				orphans = append(orphans, values[i], values[i])
+			}
+		}
+
+		n, err := a.removeOrphans(conn, index, "ZREM", ekey, orphans, repair)
+		r.OrphanEntries += n
+
+		return err
+	})
+}
+
+// verifyOrphans counts and optionally removes the members of a ZSET that are
+// not stored documents
+func (a *Autocomplete) verifyOrphans(conn *redisConn, index, zkey string,
+	docs map[string]bool, repair bool, r *VerifyReport) error {
+
+	return scan(conn, "ZSCAN", zkey, "", func(values []string) error {
+		orphans := []string{}
+		for i := 0; i < len(values); i += 2 {
+			if !docs[values[i]] {
+				orphans = append(orphans, values[i], values[i])
+			}
+		}
+
+		n, err := a.removeOrphans(conn, index, "ZREM", zkey, orphans, repair)
+		r.OrphanMembers += n
+
+		return err
+	})
+}
+
+// removeOrphans returns the number of orphans, given as pairs of a ZSET member
+// or a hash field and its document key, and when repair is true removes them
+// with cmd. the documents are checked again atomically with the removal, so
+// documents stored after the scan started are kept and not counted.
+func (a *Autocomplete) removeOrphans(conn *redisConn, index, cmd, key string,
+	orphans []string, repair bool) (int, error) {
+
+	if !repair || len(orphans) == 0 {
+		return len(orphans) / 2, nil
+	}
+
+	args := []interface{}{a.documentsKey(index), key, cmd}
+	for _, o := range orphans {
+		args = append(args, o)
+	}
+
+	return replyInt(a.runScript(conn, "removeOrphans", args...))
+}
+
+// verifyDocuments checks that every document is a member of all of the ZSETs
+// of its term with the same score
+func (a *Autocomplete) verifyDocuments(conn *redisConn, indexType int,
+	index string, batch []string, terms map[string]string, members map[string][]string,
+	repair bool, r *VerifyReport) error {
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	// the ZSETs of every document and its scores in them
+	zkeys := make([][]string, len(batch))
+	scores := make([][]*float64, len(batch))
+
+	for i, docKey := range batch {
+		if indexType == PrefixesIndexing {
+			for _, p := range a.termPrefixes(terms[docKey]) {
+				zkeys[i] = append(zkeys[i], a.prefixKey(index, p))
+			}
+		}
+
+		if a.phonetic {
+			zkeys[i] = append(zkeys[i], a.phoneticKeys(index, terms[docKey])...)
+		}
+
+		for _, k := range zkeys[i] {
+			if err := conn.Send("ZSCORE", k, docKey); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	for i := range batch {
+		for range zkeys[i] {
+			s, err := replyFloat64(conn.Receive())
+			if err == errNil {
+				scores[i] = append(scores[i], nil)
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			scores[i] = append(scores[i], &s)
+		}
+	}
+
+	for i, docKey := range batch {
+		var max *float64
+		missing := false
+		mismatch := false
+
+		check := func(s *float64) {
+			if s == nil {
+				r.MissingPrefixes++
+				missing = true
+				return
+			}
+
+			if max != nil && *s != *max {
+				mismatch = true
+			}
+
+			if max == nil || *s > *max {
+				max = s
+			}
+		}
+
+		// the terms indexing member with the highest score is kept
+		best := ""
+		if indexType == TermsIndexing {
+			if len(members[docKey]) == 0 {
+				check(nil)
+			}
+
+			for _, m := range members[docKey] {
+				_, u, _, err := a.decodeMember(m)
+				if err != nil {
+					return err
+				}
+
+				s := float64(u)
+				if max == nil || s > *max {
+					best = m
+				}
+
+				check(&s)
+			}
+		}
+
+		for _, s := range scores[i] {
+			check(s)
+		}
+
+		if mismatch {
+			r.MismatchedScores++
+		}
+
+		if !repair || (!missing && !mismatch) {
+			continue
+		}
+
+		score := 0.0
+		if max != nil {
+			score = *max
+		}
+
+		if err := a.repairDocument(conn, indexType, index, docKey,
+			terms[docKey], score, best, members[docKey], zkeys[i]); err != nil {
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// repairDocument sets the score of a document in all of its ZSETs, in terms
+// indexing only the given best member is kept
+func (a *Autocomplete) repairDocument(conn *redisConn, indexType int, index,
+	docKey, term string, score float64, best string, members,
+	zkeys []string) error {
+
+	if err := conn.Send("MULTI"); err != nil {
+		return err
+	}
+
+	if indexType == TermsIndexing {
+		zkey := a.termsIndexKey(index)
+
+		for _, m := range members {
+			if m != best {
+				if err := conn.Send("ZREM", zkey, m); err != nil {
+					return err
+				}
+			}
+		}
+
+		if best == "" {
+			val, err := a.encodeMember(term, uint64(score), docKey)
+			if err != nil {
+				return err
+			}
+
+			if err := conn.Send("ZADD", zkey, 0, val); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, k := range zkeys {
+		if err := conn.Send("ZADD", k, strconv.FormatFloat(score, 'f', -1, 64),
+			docKey); err != nil {
+
+			return err
+		}
+	}
+
+	if err := replyError(conn.Do("EXEC")); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// scan iterates a SCAN family command and passes every returned batch of
+// elements to fn, key is ignored for SCAN and match is optional
+func scan(conn *redisConn, cmd, key, match string,
+	fn func([]string) error) error {
+
+	cursor := "0"
+	for {
+		args := []interface{}{}
+		if cmd != "SCAN" {
+			args = append(args, key)
+		}
+
+		args = append(args, cursor)
+		if match != "" {
+			args = append(args, "MATCH", match)
+		}
+
+		args = append(args, "COUNT", scanCount)
+
+		values, err := replyValues(conn.Do(cmd, args...))
+		if err != nil {
+			return err
+		}
+
+		if len(values) != 2 {
+			return fmt.Errorf("invalid %s reply %v", cmd, values)
+		}
+
+		if cursor, err = replyString(values[0], nil); err != nil {
+			return err
+		}
+
+		elements, err := replyStrings(values[1], nil)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(elements); err != nil {
+			return err
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// scanKeys scans the keys that extend p by a single key field and passes every
+// returned batch of keys to fn. keys that extend p by more than one field
+// belong to other indexes in the legacy key schema, e.g. the keys of index
+// "a:b" extend the prefix keys of index "a" by two fields.
+func scanKeys(conn *redisConn, p string, fn func([]string) error) error {
+	return scan(conn, "SCAN", "", globEscape(p)+"*",
+		func(keys []string) error {
+			owned := []string{}
+			for _, k := range keys {
+				if !strings.Contains(k[len(p):], ":") {
+					owned = append(owned, k)
+				}
+			}
+
+			return fn(owned)
+		})
+}
+
+// globEscape escapes the special characters of a glob style pattern
+func globEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`,
+		`]`, `\]`)
+
+	return r.Replace(s)
+}
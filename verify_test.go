@@ -0,0 +1,9 @@
+package autocomplete
+
+import "testing"
+
+func TestGlobEscape(t *testing.T) {
+	if globEscape(`ac:a*b?[c]\`) != `ac:a\*b\?\[c\]\\` {
+		t.Fail()
+	}
+}